@@ -0,0 +1,156 @@
+package message
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBufferSize is the capacity of the ReadMessage channel when
+// ClientConfig.BufferSize is not set
+const DefaultBufferSize = 10000
+
+// OverflowPolicy decides what Listen does with an incoming message when the
+// ReadMessage channel is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest discards the incoming message
+	OverflowDropNewest OverflowPolicy = iota
+
+	// OverflowDropOldest discards the oldest queued message to make room
+	OverflowDropOldest
+
+	// OverflowBlock waits for room, which stops reading from the Connection
+	// and applies backpressure to the peer
+	OverflowBlock
+
+	// OverflowDropByPriority sheds queued events to make room for requests,
+	// responses and errors. Incoming events are discarded.
+	OverflowDropByPriority
+)
+
+// String returns the policy name used in logs
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowDropByPriority:
+		return "drop_by_priority"
+	default:
+		return "unknown"
+	}
+}
+
+// forward delivers a message to the ReadMessage channel, applying the
+// overflow policy when it is full
+func (c *client) forward(ctx context.Context, msg GenericMessage) {
+	// Close waits for in-flight forwards before closing the channel.
+	c.forwardMutex.RLock()
+	defer c.forwardMutex.RUnlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	// Shedding drains and refills the channel, so forwards from dispatcher
+	// workers must not queue messages meanwhile.
+	if c.overflow == OverflowDropByPriority {
+		c.shedMutex.Lock()
+		defer c.shedMutex.Unlock()
+	}
+
+	select {
+	case c.msgCh <- msg:
+		// Only log trace if enabled to reduce overhead
+		if c.logger.Logger.IsLevelEnabled(log.TraceLevel) {
+			c.logger.Trace("Message received and forwarded")
+		}
+		if c.printConfig != nil {
+			Print(msg, c.printConfig)
+		}
+		return
+	default:
+	}
+
+	switch c.overflow {
+	case OverflowBlock:
+		select {
+		case c.msgCh <- msg:
+		case <-ctx.Done():
+			c.drop(msg)
+		case <-c.done:
+			c.drop(msg)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case c.msgCh <- msg:
+				return
+			default:
+			}
+			select {
+			case oldest := <-c.msgCh:
+				c.drop(oldest)
+			default:
+			}
+		}
+	case OverflowDropByPriority:
+		if _, isEvent := msg.(EventMessage); isEvent || !c.shedEvent() {
+			c.drop(msg)
+			return
+		}
+		select {
+		case c.msgCh <- msg:
+		default:
+			c.drop(msg)
+		}
+	default:
+		c.drop(msg)
+	}
+}
+
+// shedEvent removes the oldest queued event while keeping the order of the
+// remaining messages. It reports whether an event was removed. The caller
+// holds shedMutex, so the refill always has room.
+func (c *client) shedEvent() bool {
+	queued := make([]GenericMessage, 0, len(c.msgCh))
+drain:
+	for {
+		select {
+		case m := <-c.msgCh:
+			queued = append(queued, m)
+		default:
+			break drain
+		}
+	}
+
+	shed := false
+	for _, m := range queued {
+		if _, isEvent := m.(EventMessage); isEvent && !shed {
+			shed = true
+			c.drop(m)
+			continue
+		}
+		select {
+		case c.msgCh <- m:
+		default:
+			c.drop(m)
+		}
+	}
+	return shed
+}
+
+// drop reports a message discarded by the overflow policy
+func (c *client) drop(msg GenericMessage) {
+	c.logger.WithField("policy", c.overflow.String()).Warn("GenericMessage channel full, dropping message")
+	if c.onDrop != nil {
+		c.onDrop(msg)
+	}
+}
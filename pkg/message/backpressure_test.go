@@ -0,0 +1,241 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropRecorder collects messages passed to OnDrop
+type dropRecorder struct {
+	mutex   sync.Mutex
+	dropped []GenericMessage
+}
+
+func (r *dropRecorder) record(msg GenericMessage) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dropped = append(r.dropped, msg)
+}
+
+func (r *dropRecorder) actions(t *testing.T, want int) []string {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return len(r.dropped) >= want
+	}, time.Second, 5*time.Millisecond)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return messageActions(r.dropped)
+}
+
+func messageActions(msgs []GenericMessage) []string {
+	actions := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		switch m := msg.(type) {
+		case EventMessage:
+			actions = append(actions, m.Action)
+		case RequestMessage:
+			actions = append(actions, m.Action)
+		case ResponseMessage:
+			actions = append(actions, m.Action)
+		}
+	}
+	return actions
+}
+
+func readN(t *testing.T, client Client, n int) []string {
+	t.Helper()
+	var msgs []GenericMessage
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-client.ReadMessage():
+			msgs = append(msgs, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after %d messages", i)
+		}
+	}
+	return messageActions(msgs)
+}
+
+func pushEvents(conn *MockConnection, actions ...string) {
+	for _, action := range actions {
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": action})
+	}
+}
+
+func TestClient_OverflowPolicy(t *testing.T) {
+	t.Run("drop newest by default", func(t *testing.T) {
+		recorder := &dropRecorder{}
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			BufferSize: 2,
+			OnDrop:     recorder.record,
+		})
+		defer cancel()
+
+		pushEvents(conn, "e1", "e2", "e3", "e4")
+
+		assert.Equal(t, []string{"e3", "e4"}, recorder.actions(t, 2))
+		assert.Equal(t, []string{"e1", "e2"}, readN(t, client, 2))
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		recorder := &dropRecorder{}
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			BufferSize: 2,
+			Overflow:   OverflowDropOldest,
+			OnDrop:     recorder.record,
+		})
+		defer cancel()
+
+		pushEvents(conn, "e1", "e2", "e3", "e4")
+
+		assert.Equal(t, []string{"e1", "e2"}, recorder.actions(t, 2))
+		assert.Equal(t, []string{"e3", "e4"}, readN(t, client, 2))
+	})
+
+	t.Run("block keeps every message", func(t *testing.T) {
+		recorder := &dropRecorder{}
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			BufferSize: 2,
+			Overflow:   OverflowBlock,
+			OnDrop:     recorder.record,
+		})
+		defer cancel()
+
+		var actions []string
+		for i := 0; i < 20; i++ {
+			actions = append(actions, fmt.Sprintf("e%d", i))
+		}
+		pushEvents(conn, actions...)
+
+		// Listen stops reading from the connection while the channel is full
+		require.Eventually(t, func() bool { return len(conn.msgCh) > 0 }, time.Second, 5*time.Millisecond)
+
+		assert.Equal(t, actions, readN(t, client, len(actions)))
+		assert.Empty(t, recorder.dropped)
+	})
+
+	t.Run("block releases on close", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			BufferSize: 1,
+			Overflow:   OverflowBlock,
+		})
+		defer cancel()
+
+		pushEvents(conn, "e1", "e2", "e3")
+		time.Sleep(20 * time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			_ = client.Close()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Close blocked by full channel")
+		}
+	})
+
+	t.Run("drop by priority sheds events", func(t *testing.T) {
+		recorder := &dropRecorder{}
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			BufferSize: 3,
+			Overflow:   OverflowDropByPriority,
+			OnDrop:     recorder.record,
+		})
+		defer cancel()
+
+		pushEvents(conn, "e1")
+		pushMessage(conn, map[string]any{"type": TypeResponse, "action": "r1", "reply_to": "req-1"})
+		pushEvents(conn, "e2")
+		// Full: the incoming event is discarded
+		pushEvents(conn, "e3")
+		// Full: the oldest queued event makes room for the response
+		pushMessage(conn, map[string]any{"type": TypeResponse, "action": "r2", "reply_to": "req-2"})
+
+		assert.Equal(t, []string{"e3", "e1"}, recorder.actions(t, 2))
+		assert.Equal(t, []string{"r1", "e2", "r2"}, readN(t, client, 3))
+	})
+}
+
+// Ensure a forward from another dispatcher worker cannot take the room made
+// by shedding an event
+func TestClient_ForwardShedConcurrent(t *testing.T) {
+	var impl *client
+	var once sync.Once
+	concurrent := make(chan struct{})
+	recorder := &dropRecorder{}
+	impl = NewClient(logrus.NewEntry(logrus.New()), NewMockConnection(), ClientConfig{
+		BufferSize: 2,
+		Overflow:   OverflowDropByPriority,
+		OnDrop: func(msg GenericMessage) {
+			recorder.record(msg)
+			once.Do(func() {
+				go func() {
+					impl.forward(context.Background(), ResponseMessage{Action: "r2"})
+					close(concurrent)
+				}()
+				select {
+				case <-concurrent:
+				case <-time.After(50 * time.Millisecond):
+				}
+			})
+		},
+	}).(*client)
+
+	impl.forward(context.Background(), EventMessage{Action: "e1"})
+	impl.forward(context.Background(), EventMessage{Action: "e2"})
+	impl.forward(context.Background(), ResponseMessage{Action: "r1"})
+	<-concurrent
+
+	assert.Equal(t, []string{"e1", "e2"}, recorder.actions(t, 2))
+	assert.ElementsMatch(t, []string{"r1", "r2"}, messageActions([]GenericMessage{<-impl.msgCh, <-impl.msgCh}))
+}
+
+func TestOverflowPolicy_String(t *testing.T) {
+	assert.Equal(t, "drop_newest", OverflowDropNewest.String())
+	assert.Equal(t, "drop_oldest", OverflowDropOldest.String())
+	assert.Equal(t, "block", OverflowBlock.String())
+	assert.Equal(t, "drop_by_priority", OverflowDropByPriority.String())
+	assert.Equal(t, "unknown", OverflowPolicy(42).String())
+}
+
+// Ensure a blocking forward honours the listen context
+func TestClient_ForwardBlockCancelled(t *testing.T) {
+	conn := NewMockConnection()
+	recorder := &dropRecorder{}
+	impl := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{
+		BufferSize: 1,
+		Overflow:   OverflowBlock,
+		OnDrop:     recorder.record,
+	}).(*client)
+
+	impl.forward(context.Background(), EventMessage{Action: "e1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	impl.forward(ctx, EventMessage{Action: "e2"})
+
+	assert.Equal(t, []string{"e2"}, recorder.actions(t, 1))
+}
@@ -0,0 +1,162 @@
+package message
+
+import (
+	"context"
+)
+
+// BatchCall is one request sent with CallBatch
+type BatchCall struct {
+	Action  MessageAction
+	Payload any
+}
+
+// BatchResult is the outcome of one BatchCall: the response, or the error
+// Call would have returned for it
+type BatchResult struct {
+	Response *ResponseMessage
+	Err      error
+}
+
+// batchReplies collects the replies to the requests of a batch being handled.
+// pending counts the messages of the batch still queued on the dispatcher,
+// plus one while the batch itself is being received.
+type batchReplies struct {
+	requests []inflightKey
+	replies  []GenericMessage
+	pending  int
+}
+
+// batchContextKey carries the batch a message belongs to
+type batchContextKey struct{}
+
+// CallBatch sends the calls as a single batch frame and waits for all their
+// replies. The returned error only reports a batch that could not be sent;
+// the outcome of each call, including context expiry, is in its result.
+func (c *client) CallBatch(ctx context.Context, calls []BatchCall, channelID ChannelID) ([]BatchResult, error) {
+	requests := make([]RequestMessage, len(calls))
+	pending := make([]*pendingCall, len(calls))
+	messages := make([]GenericMessage, len(calls))
+	for i, call := range calls {
+		requests[i] = RequestMessage{
+			Action:    call.Action,
+			Payload:   call.Payload,
+			Source:    c.source,
+			RequestID: c.newRequestID(),
+			ChannelID: channelID,
+			TimeoutMs: requestTimeout(ctx),
+		}
+		messages[i] = requests[i]
+
+		registered, err := c.registerCall(requests[i], callOptions{})
+		if err != nil {
+			c.removeCalls(requests[:i])
+			return nil, err
+		}
+		pending[i] = registered
+	}
+
+	if err := c.Send(BatchMessage{Source: c.source, Messages: messages}, &channelID); err != nil {
+		c.removeCalls(requests)
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(calls))
+	for i := range requests {
+		results[i].Response, results[i].Err = c.awaitReply(ctx, requests[i], pending[i], nil)
+	}
+	return results, nil
+}
+
+// removeCalls forgets the pending calls of requests
+func (c *client) removeCalls(requests []RequestMessage) {
+	for _, req := range requests {
+		c.removeCall(req.RequestID)
+	}
+}
+
+// receiveBatch handles the messages of a batch in order. Replies to its
+// requests sent while it is handled, including by the dispatcher, are
+// coalesced into one batch frame; later replies are sent on their own.
+func (c *client) receiveBatch(ctx context.Context, batch BatchMessage) {
+	collector := &batchReplies{pending: 1}
+	c.batchMutex.Lock()
+	for _, msg := range batch.Messages {
+		if req, ok := msg.(RequestMessage); ok {
+			key := inflightKey{channelID: req.ChannelID, requestID: req.RequestID}
+			collector.requests = append(collector.requests, key)
+			c.batches[key] = collector
+		}
+	}
+	c.batchMutex.Unlock()
+
+	ctx = context.WithValue(ctx, batchContextKey{}, collector)
+	for _, msg := range batch.Messages {
+		c.receive(ctx, msg)
+	}
+	c.settleBatch(ctx)
+}
+
+// holdBatch keeps the batch of ctx open until settleBatch is called
+func (c *client) holdBatch(ctx context.Context) {
+	collector, ok := ctx.Value(batchContextKey{}).(*batchReplies)
+	if !ok {
+		return
+	}
+	c.batchMutex.Lock()
+	collector.pending++
+	c.batchMutex.Unlock()
+}
+
+// settleBatch releases the batch of ctx and sends its replies once nothing
+// holds it anymore
+func (c *client) settleBatch(ctx context.Context) {
+	collector, ok := ctx.Value(batchContextKey{}).(*batchReplies)
+	if !ok {
+		return
+	}
+
+	c.batchMutex.Lock()
+	collector.pending--
+	if collector.pending > 0 {
+		c.batchMutex.Unlock()
+		return
+	}
+	for _, key := range collector.requests {
+		if c.batches[key] == collector {
+			delete(c.batches, key)
+		}
+	}
+	replies := collector.replies
+	c.batchMutex.Unlock()
+
+	if len(replies) == 0 {
+		return
+	}
+	if err := c.writeFrame(BatchMessage{Source: c.source, Messages: replies}); err != nil {
+		c.logger.WithError(err).Error("Failed to send batch reply")
+	}
+}
+
+// collectBatchReply holds back a reply to a request of a batch being
+// handled. It reports whether the reply was collected.
+func (c *client) collectBatchReply(msg GenericMessage) bool {
+	var key inflightKey
+	switch m := msg.(type) {
+	case ResponseMessage:
+		key = inflightKey{channelID: m.ChannelID, requestID: m.ReplyTo}
+	case ErrorMessage:
+		key = inflightKey{channelID: m.ChannelID, requestID: m.ReplyTo}
+	default:
+		return false
+	}
+
+	c.batchMutex.Lock()
+	defer c.batchMutex.Unlock()
+
+	collector, ok := c.batches[key]
+	if !ok {
+		return false
+	}
+	collector.replies = append(collector.replies, msg)
+	return true
+}
@@ -0,0 +1,217 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func settingsRouter() *Router {
+	router := NewRouter()
+	router.Handle("settings.get", func(ctx context.Context, req *RequestMessage, res Responder) {
+		_ = res.Reply(map[string]any{"name": req.Payload})
+	})
+	return router
+}
+
+func TestClient_CallBatch(t *testing.T) {
+	t.Run("returns results in order", func(t *testing.T) {
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: settingsRouter()}, ClientConfig{Source: SystemAPI})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		results, err := api.CallBatch(ctx, []BatchCall{
+			{Action: "settings.get", Payload: "iso"},
+			{Action: "settings.reset"},
+			{Action: "settings.get", Payload: "shutter"},
+		}, "channel-1")
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		require.NoError(t, results[0].Err)
+		assert.Equal(t, map[string]any{"name": "iso"}, results[0].Response.Payload)
+		var errResponse *ErrorResponse
+		require.ErrorAs(t, results[1].Err, &errResponse)
+		assert.Equal(t, CodeNotFound, errResponse.Code)
+		require.NoError(t, results[2].Err)
+		assert.Equal(t, map[string]any{"name": "shutter"}, results[2].Response.Payload)
+		assert.Equal(t, 0, pendingCount(api))
+	})
+
+	t.Run("sends a single frame", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, callCancel := context.WithCancel(context.Background())
+		defer callCancel()
+		go func() {
+			_, _ = client.CallBatch(ctx, []BatchCall{{Action: "settings.get"}, {Action: "settings.get"}}, "channel-1")
+		}()
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeBatch, envelope["type"])
+		assert.Equal(t, SystemAPI, envelope["source"])
+		messages := envelope["messages"].([]any)
+		require.Len(t, messages, 2)
+		for _, msg := range messages {
+			req := msg.(map[string]any)
+			assert.Equal(t, TypeRequest, req["type"])
+			assert.Equal(t, "channel-1", req["channel_id"])
+			assert.NotEmpty(t, req["request_id"])
+		}
+	})
+
+	t.Run("accepts replies sent one by one", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+			var batch struct {
+				Messages []map[string]any `json:"messages"`
+			}
+			_ = json.Unmarshal(args.Get(0).([]byte), &batch)
+			for i := len(batch.Messages) - 1; i >= 0; i-- {
+				req := batch.Messages[i]
+				pushMessage(conn, map[string]any{"type": TypeResponse, "action": req["action"], "reply_to": req["request_id"], "payload": i})
+			}
+		}).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		results, err := client.CallBatch(context.Background(), []BatchCall{{Action: "a"}, {Action: "b"}}, "")
+		require.NoError(t, err)
+		assert.Equal(t, float64(0), results[0].Response.Payload)
+		assert.Equal(t, float64(1), results[1].Response.Payload)
+	})
+
+	t.Run("context expiry is reported per call", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, callCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer callCancel()
+		results, err := client.CallBatch(ctx, []BatchCall{{Action: "a"}, {Action: "b"}}, "")
+		require.NoError(t, err)
+		for _, result := range results {
+			assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+		}
+		assert.Equal(t, 0, pendingCount(client))
+	})
+
+	t.Run("closed client", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+		require.NoError(t, client.Close())
+
+		_, err := client.CallBatch(context.Background(), []BatchCall{{Action: "a"}}, "")
+		assert.ErrorIs(t, err, ErrClientClosed)
+	})
+}
+
+func TestClient_ReceiveBatch(t *testing.T) {
+	conn := NewMockConnection()
+	sent := captureSent(conn)
+	_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: settingsRouter()})
+	defer cancel()
+
+	pushMessage(conn, map[string]any{
+		"type":   TypeBatch,
+		"source": SystemAPI,
+		"messages": []map[string]any{
+			{"type": TypeRequest, "action": "settings.get", "request_id": "req-1", "payload": "iso"},
+			{"type": TypeRequest, "action": "settings.unknown", "request_id": "req-2"},
+		},
+	})
+
+	envelope := waitSent(t, sent)
+	assert.Equal(t, TypeBatch, envelope["type"])
+	assert.Equal(t, SystemDevice, envelope["source"])
+	replies := envelope["messages"].([]any)
+	require.Len(t, replies, 2)
+	assert.Equal(t, TypeResponse, replies[0].(map[string]any)["type"])
+	assert.Equal(t, "req-1", replies[0].(map[string]any)["reply_to"])
+	assert.Equal(t, TypeError, replies[1].(map[string]any)["type"])
+	assert.Equal(t, "req-2", replies[1].(map[string]any)["reply_to"])
+	assertNothingSent(t, sent)
+}
+
+func TestClient_ReceiveBatchWithDispatcher(t *testing.T) {
+	conn := NewMockConnection()
+	sent := captureSent(conn)
+	_, cancel := newListeningClient(t, conn, ClientConfig{
+		Source:     SystemDevice,
+		Router:     settingsRouter(),
+		Dispatcher: &DispatcherConfig{Workers: 4},
+	})
+	defer cancel()
+
+	pushMessage(conn, map[string]any{
+		"type":   TypeBatch,
+		"source": SystemAPI,
+		"messages": []map[string]any{
+			{"type": TypeRequest, "action": "settings.get", "request_id": "req-1", "channel_id": "channel-1"},
+			{"type": TypeRequest, "action": "settings.get", "request_id": "req-2", "channel_id": "channel-2"},
+			{"type": TypeRequest, "action": "settings.get", "request_id": "req-3", "channel_id": "channel-3"},
+		},
+	})
+
+	envelope := waitSent(t, sent)
+	assert.Equal(t, TypeBatch, envelope["type"])
+	var replyTo []any
+	for _, reply := range envelope["messages"].([]any) {
+		replyTo = append(replyTo, reply.(map[string]any)["reply_to"])
+	}
+	assert.ElementsMatch(t, []any{"req-1", "req-2", "req-3"}, replyTo)
+	assertNothingSent(t, sent)
+}
+
+func TestUnmarshalBatchMessage(t *testing.T) {
+	for _, codec := range allCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, codec.Encode(&buf, map[string]any{
+				"type":   TypeBatch,
+				"source": SystemAPI,
+				"messages": []any{
+					map[string]any{"type": TypeRequest, "action": "settings.get", "request_id": "req-1"},
+					map[string]any{"type": TypeEvent, "action": "telemetry", "timestamp": 1},
+				},
+			}))
+
+			msg, err := UnmarshalMessageWithCodec(buf.Bytes(), codec)
+			require.NoError(t, err)
+			batch, ok := msg.(BatchMessage)
+			require.True(t, ok)
+			assert.Equal(t, SystemAPI, batch.Source)
+			require.Len(t, batch.Messages, 2)
+			assert.Equal(t, "req-1", batch.Messages[0].(RequestMessage).RequestID)
+			assert.Equal(t, "telemetry", batch.Messages[1].(EventMessage).Action)
+
+			_, err = UnmarshalMessageWithOptions(buf.Bytes(), ParseOptions{Codec: codec, Strict: true})
+			assert.ErrorIs(t, err, ErrMissingTimestamp)
+		})
+	}
+
+	t.Run("rejects invalid batches", func(t *testing.T) {
+		_, err := UnmarshalMessage([]byte(`{"type": "batch"}`))
+		assert.ErrorContains(t, err, "'messages'")
+
+		_, err = UnmarshalMessage([]byte(`{"type": "batch", "messages": [{"type": "batch", "messages": []}]}`))
+		assert.ErrorContains(t, err, "batch cannot contain a batch")
+
+		_, err = UnmarshalMessage([]byte(`{"type": "batch", "messages": [{"type": "request", "action": "a"}]}`))
+		assert.ErrorIs(t, err, ErrMissingRequestID)
+
+		assert.Error(t, validateMessage(map[string]any{"type": TypeBatch}))
+		assert.NoError(t, validateMessage(map[string]any{"type": TypeBatch, "messages": []any{}}))
+	})
+}
@@ -0,0 +1,236 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// abandonedCallsLimit bounds how many timed out request IDs are remembered
+// so that their late replies can be recognised and dropped
+const abandonedCallsLimit = 1024
+
+// pendingCall is a request sent by Call or CallStream that is waiting for
+// its reply
+type pendingCall struct {
+	replyCh   chan GenericMessage
+	options   callOptions
+	channelID ChannelID
+
+	// failed is closed with err set when the channel of the call closes
+	failed chan struct{}
+	err    error
+
+	// stream calls stay registered until their stream ends. closed is
+	// closed when the client closes, stopped when the stream is done and
+	// overflow when the caller falls too far behind.
+	stream       bool
+	closed       chan struct{}
+	stopped      chan struct{}
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+// Error implements the error interface so that an ErrorResponse received
+// from the peer can be returned directly to Go callers
+func (e *ErrorResponse) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Call sends a request and blocks until the matching response or error
+// arrives, the context is done, the channel closes or the client is closed.
+// An ErrorMessage reply is returned as an *ErrorResponse error.
+func (c *client) Call(ctx context.Context, action MessageAction, payload any, channelID ChannelID, opts ...CallOption) (*ResponseMessage, error) {
+	req := RequestMessage{
+		Action:    action,
+		Payload:   payload,
+		Source:    c.source,
+		RequestID: c.newRequestID(),
+		ChannelID: channelID,
+		TimeoutMs: requestTimeout(ctx),
+	}
+
+	options := newCallOptions(opts)
+	if options.retry == nil {
+		if policy, ok := c.retry[action]; ok {
+			options.retry = &policy
+		}
+	}
+
+	call, err := c.registerCall(req, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.retry != nil {
+		return c.callWithRetry(ctx, req, call, *options.retry)
+	}
+
+	if err := c.Send(req, &channelID); err != nil {
+		c.removeCall(req.RequestID)
+		return nil, err
+	}
+	return c.awaitReply(ctx, req, call, nil)
+}
+
+// awaitReply waits for the reply to a sent request. It gives up with
+// ErrAttemptTimeout when timeout fires, leaving the call pending.
+func (c *client) awaitReply(ctx context.Context, req RequestMessage, call *pendingCall, timeout <-chan time.Time) (*ResponseMessage, error) {
+	select {
+	case reply, ok := <-call.replyCh:
+		if !ok {
+			return nil, ErrClientClosed
+		}
+		switch m := reply.(type) {
+		case ResponseMessage:
+			return &m, nil
+		case ErrorMessage:
+			return nil, &m.Error
+		default:
+			return nil, fmt.Errorf("unexpected reply type: %T", reply)
+		}
+	case <-call.failed:
+		return nil, call.err
+	case <-timeout:
+		return nil, ErrAttemptTimeout
+	case <-ctx.Done():
+		c.abandonCall(req.RequestID)
+		c.sendCancel(req, ctx.Err().Error())
+		return nil, ctx.Err()
+	}
+}
+
+// registerCall adds a pending call for the given request
+func (c *client) registerCall(req RequestMessage, options callOptions) (*pendingCall, error) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if c.pending == nil {
+		return nil, ErrClientClosed
+	}
+	call := &pendingCall{
+		replyCh:   make(chan GenericMessage, 1),
+		options:   options,
+		channelID: req.ChannelID,
+		failed:    make(chan struct{}),
+	}
+	c.pending[req.RequestID] = call
+	return call, nil
+}
+
+// removeCall forgets a pending call without waiting for its reply
+func (c *client) removeCall(id RequestID) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	delete(c.pending, id)
+}
+
+// abandonCall removes a pending call whose caller gave up and remembers its
+// ID so that a reply arriving later is dropped instead of forwarded
+func (c *client) abandonCall(id RequestID) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if _, ok := c.pending[id]; !ok {
+		return
+	}
+	delete(c.pending, id)
+	c.rememberAbandoned(id)
+}
+
+// rememberAbandoned records the ID of an abandoned call, forgetting the
+// oldest beyond abandonedCallsLimit. The pending mutex must be held.
+func (c *client) rememberAbandoned(id RequestID) {
+	if len(c.abandonedOrder) >= abandonedCallsLimit {
+		delete(c.abandoned, c.abandonedOrder[0])
+		c.abandonedOrder = c.abandonedOrder[1:]
+	}
+	c.abandoned[id] = struct{}{}
+	c.abandonedOrder = append(c.abandonedOrder, id)
+}
+
+// deliverReply routes a response or error to the Call waiting for it.
+// It reports whether the message was consumed.
+func (c *client) deliverReply(msg GenericMessage) bool {
+	var replyTo RequestID
+	switch m := msg.(type) {
+	case ResponseMessage:
+		replyTo = m.ReplyTo
+	case ErrorMessage:
+		replyTo = m.ReplyTo
+	default:
+		return false
+	}
+
+	c.pendingMutex.Lock()
+	_, late := c.abandoned[replyTo]
+	if late && isFinalReply(msg) {
+		delete(c.abandoned, replyTo)
+	}
+	call, ok := c.pending[replyTo]
+	if ok && !call.stream {
+		delete(c.pending, replyTo)
+		// A peer that gave up on the deadline may still reply afterwards.
+		if m, expired := msg.(ErrorMessage); expired && m.Error.Code == CodeDeadlineExceeded {
+			c.rememberAbandoned(replyTo)
+		}
+	}
+	c.pendingMutex.Unlock()
+
+	// A stream whose caller falls behind is failed rather than blocking Listen.
+	if ok && call.stream {
+		select {
+		case call.replyCh <- msg:
+		case <-call.closed:
+		case <-call.stopped:
+		default:
+			call.overflowOnce.Do(func() { close(call.overflow) })
+		}
+		return true
+	}
+	if ok {
+		call.replyCh <- msg
+		return true
+	}
+	if late {
+		c.logger.WithField("reply_to", replyTo).Warn("Dropping late reply for abandoned call")
+		return true
+	}
+	return false
+}
+
+// failChannelCalls fails the pending calls made on a channel that closed.
+// Replies arriving for them afterwards are dropped.
+func (c *client) failChannelCalls(channelID ChannelID) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	for id, call := range c.pending {
+		if call.channelID != channelID {
+			continue
+		}
+		call.err = fmt.Errorf("%w: '%s'", ErrChannelClosed, channelID)
+		close(call.failed)
+		delete(c.pending, id)
+		c.rememberAbandoned(id)
+	}
+}
+
+// failPendingCalls unblocks every waiting Call once the client is closed
+func (c *client) failPendingCalls() {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	for id, call := range c.pending {
+		if call.stream {
+			close(call.closed)
+		} else {
+			close(call.replyCh)
+		}
+		delete(c.pending, id)
+	}
+	c.pending = nil
+}
@@ -0,0 +1,190 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// replyWith makes the mock connection answer every request it sends with
+// the envelope built by reply
+func replyWith(conn *MockConnection, reply func(req map[string]any) map[string]any) {
+	conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+		var req map[string]any
+		_ = json.Unmarshal(args.Get(0).([]byte), &req)
+		if req["type"] != TypeRequest {
+			return
+		}
+		data, _ := json.Marshal(reply(req))
+		conn.msgCh <- data
+	}).Return(nil)
+}
+
+func newListeningClient(t *testing.T, conn *MockConnection, config ClientConfig) (Client, context.CancelFunc) {
+	t.Helper()
+	logger := logrus.NewEntry(logrus.New())
+	logger.Logger.SetLevel(logrus.ErrorLevel)
+	conn.On("ReadMessage").Return()
+	conn.On("Close").Return(nil)
+
+	client := NewClient(logger, conn, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = client.Listen(ctx)
+	}()
+	return client, cancel
+}
+
+func pendingCount(cl Client) int {
+	c := cl.(*client)
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	return len(c.pending)
+}
+
+func TestClient_Call(t *testing.T) {
+	t.Run("returns matching response", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyWith(conn, func(req map[string]any) map[string]any {
+			return map[string]any{
+				"type":     TypeResponse,
+				"action":   req["action"],
+				"source":   SystemDevice,
+				"reply_to": req["request_id"],
+				"payload":  map[string]any{"zoom": 2},
+			}
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		resp, err := client.Call(context.Background(), "camera.zoom", map[string]any{"level": 2}, "channel-1")
+		require.NoError(t, err)
+		assert.Equal(t, "camera.zoom", resp.Action)
+		assert.Equal(t, map[string]any{"zoom": float64(2)}, resp.Payload)
+
+		select {
+		case msg := <-client.ReadMessage():
+			t.Fatalf("reply should not be forwarded, got %T", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("converts error message to error", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyWith(conn, func(req map[string]any) map[string]any {
+			return map[string]any{
+				"type":     TypeError,
+				"action":   req["action"],
+				"source":   SystemDevice,
+				"reply_to": req["request_id"],
+				"error":    map[string]any{"code": "busy", "message": "camera is busy"},
+			}
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		resp, err := client.Call(context.Background(), "camera.zoom", nil, "")
+		assert.Nil(t, resp)
+
+		var errResp *ErrorResponse
+		require.True(t, errors.As(err, &errResp))
+		assert.Equal(t, "busy", errResp.Code)
+		assert.Equal(t, "busy: camera is busy", err.Error())
+	})
+
+	t.Run("drops late reply after timeout", func(t *testing.T) {
+		conn := NewMockConnection()
+		var requestID string
+		conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+			var req map[string]any
+			_ = json.Unmarshal(args.Get(0).([]byte), &req)
+			requestID = req["request_id"].(string)
+		}).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer ctxCancel()
+		_, err := client.Call(ctx, "slow", nil, "")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		assert.Zero(t, pendingCount(client))
+
+		data, _ := json.Marshal(map[string]any{
+			"type":     TypeResponse,
+			"action":   "slow",
+			"reply_to": requestID,
+		})
+		conn.msgCh <- data
+
+		select {
+		case msg := <-client.ReadMessage():
+			t.Fatalf("late reply should be dropped, got %T", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("forwards unrelated replies", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		data, _ := json.Marshal(map[string]any{
+			"type":     TypeResponse,
+			"action":   "manual",
+			"reply_to": "req-123",
+		})
+		conn.msgCh <- data
+
+		select {
+		case msg := <-client.ReadMessage():
+			resp, ok := msg.(ResponseMessage)
+			require.True(t, ok)
+			assert.Equal(t, "req-123", resp.ReplyTo)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	})
+
+	t.Run("fails pending calls on close", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := client.Call(context.Background(), "never", nil, "")
+			errCh <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		_ = client.Close()
+
+		select {
+		case err := <-errCh:
+			assert.ErrorIs(t, err, ErrClientClosed)
+		case <-time.After(time.Second):
+			t.Fatal("Call did not return after Close")
+		}
+	})
+
+	t.Run("returns send error", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(errors.New("write failed"))
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := client.Call(context.Background(), "camera.zoom", nil, "")
+		assert.EqualError(t, err, "write failed")
+
+		assert.Zero(t, pendingCount(client))
+	})
+}
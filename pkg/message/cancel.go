@@ -0,0 +1,248 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultRequestIdleTimeout is how long a request without a timeout stays
+// tracked after its handler returned without a final reply
+const DefaultRequestIdleTimeout = 5 * time.Minute
+
+// expiredRequestsLimit bounds how many requests answered with
+// deadline_exceeded are remembered so that their late replies are dropped
+const expiredRequestsLimit = 1024
+
+// inflightKey identifies a request being handled by the router
+type inflightKey struct {
+	channelID ChannelID
+	requestID RequestID
+}
+
+// inflightRequest is a routed request whose handler has not replied yet
+type inflightRequest struct {
+	key    inflightKey
+	cancel context.CancelFunc
+	stop   func() bool
+
+	// idle forgets the request once its handler returned and nothing was
+	// sent for it in a while, nil while the handler runs
+	idle *time.Timer
+}
+
+// inflightContextKey carries the tracked request a handler context belongs to
+type inflightContextKey struct{}
+
+// release cancels the handler context and stops the deadline watch
+func (r *inflightRequest) release() {
+	if r.idle != nil {
+		r.idle.Stop()
+	}
+	r.stop()
+	r.cancel()
+}
+
+// trackRequest derives the context handed to the handler of req. It is
+// cancelled when the peer cancels the request, when the final reply is sent
+// or when the client closes. A request without a timeout is also cancelled
+// once its handler returned and nothing was sent for it during
+// RequestIdleTimeout. A request carrying a timeout also gets that
+// deadline and is answered with a deadline_exceeded error when it passes,
+// after which the replies of the handler are refused.
+func (c *client) trackRequest(ctx context.Context, req RequestMessage) context.Context {
+	var cancel context.CancelFunc
+	if req.TimeoutMs > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMs)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	key := inflightKey{channelID: req.ChannelID, requestID: req.RequestID}
+	request := &inflightRequest{key: key, cancel: cancel}
+	request.stop = context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && c.expireRequest(key, request) {
+			c.replyDeadlineExceeded(req)
+		}
+	})
+
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	if previous, ok := c.inflight[key]; ok {
+		previous.release()
+	}
+	c.inflight[key] = request
+	delete(c.expired, key)
+	return context.WithValue(ctx, inflightContextKey{}, request)
+}
+
+// idleRequest starts the idle timeout of the request tracked for ctx once
+// its handler returned. A request with a timeout is bounded by its deadline.
+func (c *client) idleRequest(ctx context.Context, req RequestMessage) {
+	request, ok := ctx.Value(inflightContextKey{}).(*inflightRequest)
+	if !ok || req.TimeoutMs > 0 {
+		return
+	}
+
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	if c.inflight[request.key] != request {
+		return
+	}
+	request.idle = time.AfterFunc(c.inflightIdle, func() {
+		c.inflightMutex.Lock()
+		defer c.inflightMutex.Unlock()
+
+		if c.inflight[request.key] == request {
+			c.logger.WithField("request_id", req.RequestID).Debug("Releasing idle request")
+			request.release()
+			delete(c.inflight, request.key)
+		}
+	})
+}
+
+// touchRequest restarts the idle timeout of a request a reply was sent for
+func (c *client) touchRequest(channelID ChannelID, requestID RequestID) {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	request, ok := c.inflight[inflightKey{channelID: channelID, requestID: requestID}]
+	if ok && request.idle != nil {
+		request.idle.Reset(c.inflightIdle)
+	}
+}
+
+// expireRequest forgets request if it is still the one tracked under key
+// and remembers that its deadline passed
+func (c *client) expireRequest(key inflightKey, request *inflightRequest) bool {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	if c.inflight[key] != request {
+		return false
+	}
+	delete(c.inflight, key)
+	request.cancel()
+
+	if len(c.expiredOrder) >= expiredRequestsLimit {
+		delete(c.expired, c.expiredOrder[0])
+		c.expiredOrder = c.expiredOrder[1:]
+	}
+	c.expired[key] = struct{}{}
+	c.expiredOrder = append(c.expiredOrder, key)
+	return true
+}
+
+// checkExpired refuses a reply to a request already answered with
+// deadline_exceeded
+func (c *client) checkExpired(msg GenericMessage) error {
+	var key inflightKey
+	switch m := msg.(type) {
+	case ResponseMessage:
+		key = inflightKey{channelID: m.ChannelID, requestID: m.ReplyTo}
+	case ProgressMessage:
+		key = inflightKey{channelID: m.ChannelID, requestID: m.ReplyTo}
+	case ErrorMessage:
+		if m.Error.Code == CodeDeadlineExceeded {
+			return nil
+		}
+		key = inflightKey{channelID: m.ChannelID, requestID: m.ReplyTo}
+	default:
+		return nil
+	}
+
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	if _, ok := c.expired[key]; ok {
+		return fmt.Errorf("%w: request '%s' was already answered", context.DeadlineExceeded, key.requestID)
+	}
+	return nil
+}
+
+// replyDeadlineExceeded tells the caller that its deadline passed before
+// the handler replied
+func (c *client) replyDeadlineExceeded(req RequestMessage) {
+	c.logger.WithField("action", req.Action).WithField("request_id", req.RequestID).Debug("Request deadline exceeded")
+	err := c.SendErrorToChannel(&req, ErrorResponse{
+		Code:    CodeDeadlineExceeded,
+		Message: fmt.Sprintf("deadline exceeded for action '%s'", req.Action),
+	})
+	if err != nil {
+		c.logger.WithError(err).Debug("Failed to send deadline exceeded error")
+	}
+}
+
+// finishRequest releases the context of a request once it is answered
+func (c *client) finishRequest(channelID ChannelID, requestID RequestID) {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	key := inflightKey{channelID: channelID, requestID: requestID}
+	if request, ok := c.inflight[key]; ok {
+		request.release()
+		delete(c.inflight, key)
+	}
+}
+
+// handleCancel cancels the request named by a cancel message, or every
+// request of its channel when no request is named
+func (c *client) handleCancel(m CancelMessage) {
+	logger := c.logger.WithField("channel_id", m.ChannelID)
+	if m.RequestID == "" {
+		logger.WithField("reason", m.Reason).Debug("Peer cancelled channel requests")
+		c.cancelRequests(m.ChannelID)
+		return
+	}
+	logger.WithField("request_id", m.RequestID).WithField("reason", m.Reason).Debug("Peer cancelled request")
+	c.finishRequest(m.ChannelID, m.RequestID)
+}
+
+// cancelRequests cancels every request received on a channel
+func (c *client) cancelRequests(channelID ChannelID) {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	for key, request := range c.inflight {
+		if key.channelID == channelID {
+			request.release()
+			delete(c.inflight, key)
+		}
+	}
+}
+
+// cancelAllRequests cancels every request being handled
+func (c *client) cancelAllRequests() {
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	for key, request := range c.inflight {
+		request.release()
+		delete(c.inflight, key)
+	}
+}
+
+// requestTimeout returns the remaining budget of ctx in milliseconds, or
+// zero when it has no deadline
+func requestTimeout(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return max(time.Until(deadline).Milliseconds(), 1)
+}
+
+// sendCancel tells the peer that the caller gave up on a request
+func (c *client) sendCancel(req RequestMessage, reason string) {
+	err := c.Send(CancelMessage{
+		RequestID: req.RequestID,
+		ChannelID: req.ChannelID,
+		Reason:    reason,
+	}, &req.ChannelID)
+	if err != nil {
+		c.logger.WithError(err).WithField("request_id", req.RequestID).Debug("Failed to send cancel")
+	}
+}
@@ -0,0 +1,390 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// capturingRouter routes every request to a handler that hands its context
+// to the test without replying
+func capturingRouter(action MessageAction) (*Router, <-chan context.Context) {
+	contexts := make(chan context.Context, 10)
+	router := NewRouter()
+	router.Handle(action, func(ctx context.Context, req *RequestMessage, res Responder) {
+		contexts <- ctx
+	})
+	return router, contexts
+}
+
+func waitContext(t *testing.T, contexts <-chan context.Context) context.Context {
+	t.Helper()
+	select {
+	case ctx := <-contexts:
+		return ctx
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for handler")
+		return nil
+	}
+}
+
+func assertCancelled(t *testing.T, ctx context.Context) {
+	t.Helper()
+	select {
+	case <-ctx.Done():
+		assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+}
+
+func assertNotCancelled(t *testing.T, ctx context.Context) {
+	t.Helper()
+	select {
+	case <-ctx.Done():
+		t.Fatal("handler context was cancelled")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func pushRequest(conn *MockConnection, requestID RequestID, channelID ChannelID) {
+	pushMessage(conn, map[string]any{
+		"type":       TypeRequest,
+		"action":     "camera.record",
+		"request_id": requestID,
+		"channel_id": channelID,
+	})
+}
+
+func TestClient_Cancel(t *testing.T) {
+	t.Run("caller timeout cancels handler context", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			_, err := api.Call(ctx, "camera.record", nil, "channel-1")
+			done <- err
+		}()
+
+		handlerCtx := waitContext(t, contexts)
+		assertNotCancelled(t, handlerCtx)
+
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+		assertCancelled(t, handlerCtx)
+	})
+
+	t.Run("closing a stream cancels handler context", func(t *testing.T) {
+		router, contexts := capturingRouter("logs.tail")
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		stream, err := api.CallStream(context.Background(), "logs.tail", nil, "")
+		require.NoError(t, err)
+
+		handlerCtx := waitContext(t, contexts)
+		stream.Close()
+		assertCancelled(t, handlerCtx)
+	})
+
+	t.Run("channel cancel only affects that channel", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushRequest(conn, "req-1", "channel-1")
+		pushRequest(conn, "req-2", "channel-1")
+		pushRequest(conn, "req-3", "channel-2")
+		first, second, other := waitContext(t, contexts), waitContext(t, contexts), waitContext(t, contexts)
+
+		pushMessage(conn, map[string]any{"type": TypeCancel, "channel_id": "channel-1"})
+
+		assertCancelled(t, first)
+		assertCancelled(t, second)
+		assertNotCancelled(t, other)
+	})
+
+	t.Run("reply releases handler context", func(t *testing.T) {
+		contexts := make(chan context.Context, 1)
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(nil)
+			contexts <- ctx
+		})
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		device, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeRequest, "action": "camera.zoom", "request_id": "req-1"})
+
+		assertCancelled(t, waitContext(t, contexts))
+		impl := device.(*client)
+		impl.inflightMutex.Lock()
+		defer impl.inflightMutex.Unlock()
+		assert.Empty(t, impl.inflight)
+	})
+
+	t.Run("idle requests are released after their handler returns", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:             SystemDevice,
+			Router:             router,
+			RequestIdleTimeout: 20 * time.Millisecond,
+		})
+		defer cancel()
+
+		for i := range 100 {
+			pushRequest(conn, RequestID(fmt.Sprintf("req-%d", i)), "channel-1")
+			assertCancelled(t, waitContext(t, contexts))
+		}
+		impl := device.(*client)
+		impl.inflightMutex.Lock()
+		defer impl.inflightMutex.Unlock()
+		assert.Empty(t, impl.inflight)
+	})
+
+	t.Run("progress keeps an idle request tracked", func(t *testing.T) {
+		responders := make(chan Responder, 1)
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {
+			responders <- res
+		})
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:             SystemDevice,
+			Router:             router,
+			RequestIdleTimeout: 50 * time.Millisecond,
+		})
+		defer cancel()
+
+		pushRequest(conn, "req-1", "channel-1")
+		res := <-responders
+		for range 4 {
+			time.Sleep(20 * time.Millisecond)
+			require.NoError(t, res.Progress(50, "recording"))
+		}
+		impl := device.(*client)
+		impl.inflightMutex.Lock()
+		defer impl.inflightMutex.Unlock()
+		assert.Len(t, impl.inflight, 1)
+	})
+
+	t.Run("client close cancels handler contexts", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushRequest(conn, "req-1", "channel-1")
+		handlerCtx := waitContext(t, contexts)
+
+		require.NoError(t, client.Close())
+		assertCancelled(t, handlerCtx)
+	})
+}
+
+func TestUnmarshalCancelMessage(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type": "cancel", "request_id": "req-1", "channel_id": "channel-1", "reason": "tab closed"}`))
+	require.NoError(t, err)
+	assert.Equal(t, CancelMessage{RequestID: "req-1", ChannelID: "channel-1", Reason: "tab closed"}, msg)
+
+	_, err = UnmarshalMessage([]byte(`{"type": "cancel", "source": "api"}`))
+	assert.ErrorContains(t, err, "cancel must include")
+
+	_, err = UnmarshalMessageWithOptions([]byte(`{"type": "cancel", "request_id": "req-1"}`), ParseOptions{Strict: true})
+	assert.ErrorIs(t, err, ErrMissingTimestamp)
+
+	assert.NoError(t, validateMessage(map[string]any{"type": TypeCancel, "request_id": "req-1"}))
+	assert.Error(t, validateMessage(map[string]any{"type": TypeCancel}))
+}
+
+func TestClient_CancelInboundMiddleware(t *testing.T) {
+	var seen atomic.Int32
+	requestsOnly := func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg GenericMessage) error {
+			seen.Add(1)
+			if _, ok := msg.(RequestMessage); !ok {
+				return nil
+			}
+			return next(ctx, msg)
+		}
+	}
+	router, contexts := capturingRouter("camera.record")
+	conn := NewMockConnection()
+	captureSent(conn)
+	_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Inbound: []Middleware{requestsOnly}})
+	defer cancel()
+
+	pushRequest(conn, "req-1", "channel-1")
+	ctx := waitContext(t, contexts)
+	pushMessage(conn, map[string]any{"type": TypeCancel, "request_id": "req-1", "channel_id": "channel-1"})
+
+	assertNotCancelled(t, ctx)
+	assert.Equal(t, int32(2), seen.Load())
+}
+
+func TestClient_Deadline(t *testing.T) {
+	t.Run("call carries remaining budget", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, callCancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer callCancel()
+		go func() { _, _ = client.Call(ctx, "camera.zoom", nil, "") }()
+
+		timeout := waitSent(t, sent)["timeout_ms"].(float64)
+		assert.Greater(t, timeout, float64(0))
+		assert.LessOrEqual(t, timeout, float64(500))
+	})
+
+	t.Run("call without deadline has no budget", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, callCancel := context.WithCancel(context.Background())
+		defer callCancel()
+		go func() { _, _ = client.Call(ctx, "camera.zoom", nil, "") }()
+
+		assert.NotContains(t, waitSent(t, sent), "timeout_ms")
+	})
+
+	t.Run("handler context gets the deadline", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "camera.record",
+			"request_id": "req-1",
+			"channel_id": "channel-1",
+			"timeout_ms": 30,
+		})
+
+		handlerCtx := waitContext(t, contexts)
+		deadline, ok := handlerCtx.Deadline()
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(30*time.Millisecond), deadline, 30*time.Millisecond)
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "channel-1", envelope["channel_id"])
+		assert.Equal(t, CodeDeadlineExceeded, envelope["error"].(map[string]any)["code"])
+		assert.ErrorIs(t, handlerCtx.Err(), context.DeadlineExceeded)
+	})
+
+	t.Run("reply before deadline sends no error", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply("ok")
+		})
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeRequest, "action": "camera.zoom", "request_id": "req-1", "timeout_ms": 20})
+
+		assert.Equal(t, TypeResponse, waitSent(t, sent)["type"])
+		select {
+		case envelope := <-sent:
+			t.Fatalf("unexpected message after reply: %v", envelope)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("replies after the deadline are refused", func(t *testing.T) {
+		replied := make(chan error, 1)
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			replied <- res.Reply("late")
+		})
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeRequest, "action": "camera.record", "request_id": "req-1", "timeout_ms": 20})
+
+		assert.Equal(t, CodeDeadlineExceeded, waitSent(t, sent)["error"].(map[string]any)["code"])
+		select {
+		case err := <-replied:
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for late reply")
+		}
+		select {
+		case envelope := <-sent:
+			t.Fatalf("unexpected message after deadline: %v", envelope)
+		case <-time.After(30 * time.Millisecond):
+		}
+	})
+
+	t.Run("caller drops replies after deadline exceeded", func(t *testing.T) {
+		conn := NewMockConnection()
+		answerAttempts(conn, func(n int, req map[string]any) map[string]any {
+			deadline := busy(req)
+			deadline["error"] = map[string]any{"code": CodeDeadlineExceeded}
+			pushMessage(conn, deadline)
+			return succeed(req)
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := client.Call(context.Background(), "camera.record", nil, "")
+		var errResponse *ErrorResponse
+		require.ErrorAs(t, err, &errResponse)
+		assert.Equal(t, CodeDeadlineExceeded, errResponse.Code)
+
+		select {
+		case msg := <-client.ReadMessage():
+			t.Fatalf("late reply leaked: %v", msg)
+		case <-time.After(30 * time.Millisecond):
+		}
+	})
+
+	t.Run("caller receives deadline exceeded", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {})
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		// The caller waits on its own context a little longer than the budget it sends.
+		impl := api.(*client)
+		req := RequestMessage{Action: "camera.record", RequestID: "req-1", TimeoutMs: 20}
+		call, err := impl.registerCall(req, callOptions{})
+		require.NoError(t, err)
+		require.NoError(t, api.Send(req, nil))
+
+		select {
+		case reply := <-call.replyCh:
+			errMsg, ok := reply.(ErrorMessage)
+			require.True(t, ok)
+			assert.Equal(t, CodeDeadlineExceeded, errMsg.Error.Code)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for deadline exceeded error")
+		}
+	})
+}
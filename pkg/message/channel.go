@@ -0,0 +1,386 @@
+package message
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxClosedChannels bounds how many closed channels are remembered to tell
+// them apart from unknown ones
+const maxClosedChannels = 1000
+
+// ActionHeartbeat is the action of the events sent by ChannelConfig.HeartbeatInterval.
+// They keep the channel alive on the peer and never reach the application.
+const ActionHeartbeat MessageAction = "channel.heartbeat"
+
+// ReasonIdleTimeout is the close reason of channels expired by ChannelConfig.IdleTimeout
+const ReasonIdleTimeout = "idle_timeout"
+
+// ChannelConfig enables channel lifecycle enforcement. Messages addressed to
+// a channel that is not open are refused: requests are answered with an
+// unknown_channel or channel_closed error and other messages are dropped.
+// Messages without a channel are always accepted.
+type ChannelConfig struct {
+	// OnOpen is called when a channel is opened by either side
+	OnOpen func(ch Channel)
+
+	// OnClose is called when a channel is closed by either side, or when
+	// the client is closed
+	OnClose func(ch Channel, reason string)
+
+	// IdleTimeout closes channels on which the peer sent nothing for that
+	// long, cancelling their requests and telling the peer. Zero disables it.
+	IdleTimeout time.Duration
+
+	// HeartbeatInterval sends a heartbeat event on every open channel at
+	// that interval so that the peer does not expire them. Zero disables it.
+	HeartbeatInterval time.Duration
+}
+
+// channelManager tracks the channels opened on a connection
+type channelManager struct {
+	config ChannelConfig
+
+	mutex       sync.Mutex
+	open        map[ChannelID]*Channel
+	closed      map[ChannelID]struct{}
+	closedOrder []ChannelID
+}
+
+func newChannelManager(config *ChannelConfig) *channelManager {
+	m := &channelManager{
+		open:   make(map[ChannelID]*Channel),
+		closed: make(map[ChannelID]struct{}),
+	}
+	if config != nil {
+		m.config = *config
+	}
+	return m
+}
+
+// add registers an open channel
+func (m *channelManager) add(id ChannelID, opener MessageSource, metadata map[string]string, now time.Time) (Channel, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.open[id]; exists {
+		return Channel{}, fmt.Errorf("%w: '%s'", ErrChannelOpen, id)
+	}
+	ch := &Channel{
+		ID:           id,
+		Opener:       opener,
+		Metadata:     maps.Clone(metadata),
+		CreatedAt:    now,
+		LastActivity: now,
+		LastReceived: now,
+	}
+	m.open[id] = ch
+	delete(m.closed, id)
+	return ch.snapshot(), nil
+}
+
+// remove closes a channel and reports whether it was open
+func (m *channelManager) remove(id ChannelID) (Channel, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ch, ok := m.open[id]
+	if !ok {
+		return Channel{}, false
+	}
+	delete(m.open, id)
+
+	m.closed[id] = struct{}{}
+	m.closedOrder = append(m.closedOrder, id)
+	if len(m.closedOrder) > maxClosedChannels {
+		delete(m.closed, m.closedOrder[0])
+		m.closedOrder = m.closedOrder[1:]
+	}
+	return ch.snapshot(), true
+}
+
+// removeAll closes every channel
+func (m *channelManager) removeAll() []Channel {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channels := make([]Channel, 0, len(m.open))
+	for _, ch := range m.open {
+		channels = append(channels, ch.snapshot())
+	}
+	clear(m.open)
+	sortChannels(channels)
+	return channels
+}
+
+// touch records activity on a channel, received from the peer or not. It
+// fails with ErrChannelClosed or ErrUnknownChannel if the channel is not open.
+func (m *channelManager) touch(id ChannelID, now time.Time, received bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ch, ok := m.open[id]; ok {
+		ch.LastActivity = now
+		if received {
+			ch.LastReceived = now
+		}
+		return nil
+	}
+	return m.state(id)
+}
+
+// idle returns the open channels on which nothing was received since before
+func (m *channelManager) idle(before time.Time) []ChannelID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var ids []ChannelID
+	for id, ch := range m.open {
+		if ch.LastReceived.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// ids returns the open channels
+func (m *channelManager) ids() []ChannelID {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Sorted(maps.Keys(m.open))
+}
+
+// check fails with ErrChannelClosed or ErrUnknownChannel if the channel is
+// not open
+func (m *channelManager) check(id ChannelID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.open[id]; ok {
+		return nil
+	}
+	return m.state(id)
+}
+
+// state tells a closed channel from an unknown one. The sentinel errors are
+// returned as is since most callers ignore them.
+func (m *channelManager) state(id ChannelID) error {
+	if _, ok := m.closed[id]; ok {
+		return ErrChannelClosed
+	}
+	return ErrUnknownChannel
+}
+
+// get returns an open channel
+func (m *channelManager) get(id ChannelID) (Channel, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ch, ok := m.open[id]
+	if !ok {
+		return Channel{}, false
+	}
+	return ch.snapshot(), true
+}
+
+// list returns the open channels, oldest first
+func (m *channelManager) list() []Channel {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channels := make([]Channel, 0, len(m.open))
+	for _, ch := range m.open {
+		channels = append(channels, ch.snapshot())
+	}
+	sortChannels(channels)
+	return channels
+}
+
+// snapshot copies a channel so that it can leave the manager
+func (ch *Channel) snapshot() Channel {
+	out := *ch
+	out.Metadata = maps.Clone(ch.Metadata)
+	return out
+}
+
+func sortChannels(channels []Channel) {
+	slices.SortFunc(channels, func(a, b Channel) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// OpenChannel opens a channel and announces it to the peer
+func (c *client) OpenChannel(id ChannelID, metadata map[string]string) (Channel, error) {
+	if id == "" {
+		return Channel{}, fmt.Errorf("%w: empty channel id", ErrUnknownChannel)
+	}
+	ch, err := c.channels.add(id, c.source, metadata, time.Now())
+	if err != nil {
+		return Channel{}, err
+	}
+
+	open := ChannelOpenMessage{Metadata: metadata}
+	if err := c.Send(open, &id); err != nil {
+		c.channels.remove(id)
+		return Channel{}, fmt.Errorf("failed to open channel: %w", err)
+	}
+	c.onChannelOpen(ch)
+	return ch, nil
+}
+
+// CloseChannel closes a channel, cancelling the requests being handled on
+// it, and tells the peer
+func (c *client) CloseChannel(id ChannelID, reason string) error {
+	ch, ok := c.channels.remove(id)
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrUnknownChannel, id)
+	}
+	c.channelClosed(ch, reason)
+
+	if err := c.Send(ChannelCloseMessage{Reason: reason}, &id); err != nil {
+		return fmt.Errorf("failed to close channel: %w", err)
+	}
+	return nil
+}
+
+// Channels returns the open channels, oldest first
+func (c *client) Channels() []Channel {
+	return c.channels.list()
+}
+
+// LookupChannel returns an open channel
+func (c *client) LookupChannel(id ChannelID) (Channel, bool) {
+	return c.channels.get(id)
+}
+
+// handleChannelOpen registers a channel opened by the peer
+func (c *client) handleChannelOpen(m ChannelOpenMessage) {
+	ch, err := c.channels.add(m.ChannelID, m.Source, m.Metadata, time.Now())
+	if err != nil {
+		c.logger.WithError(err).Debug("Ignoring channel open")
+		return
+	}
+	c.onChannelOpen(ch)
+}
+
+// handleChannelClose closes a channel closed by the peer
+func (c *client) handleChannelClose(m ChannelCloseMessage) {
+	ch, ok := c.channels.remove(m.ChannelID)
+	if !ok {
+		c.logger.WithField("channel_id", m.ChannelID).Debug("Ignoring close of unknown channel")
+		return
+	}
+	c.channelClosed(ch, m.Reason)
+}
+
+// closeChannels closes every channel when the client is closed
+func (c *client) closeChannels() {
+	c.invalidateViews()
+	for _, ch := range c.channels.removeAll() {
+		c.onChannelClose(ch, "client closed")
+	}
+}
+
+// channelClosed cancels the requests received on a closed channel, fails
+// the calls made on it and invalidates its view
+func (c *client) channelClosed(ch Channel, reason string) {
+	c.cancelRequests(ch.ID)
+	c.failChannelCalls(ch.ID)
+	c.invalidateView(ch.ID)
+	c.onChannelClose(ch, reason)
+}
+
+func (c *client) onChannelOpen(ch Channel) {
+	if c.channels.config.OnOpen != nil {
+		c.channels.config.OnOpen(ch)
+	}
+}
+
+func (c *client) onChannelClose(ch Channel, reason string) {
+	if c.channels.config.OnClose != nil {
+		c.channels.config.OnClose(ch, reason)
+	}
+}
+
+// admitChannel records activity on the channel of an incoming message and
+// reports whether it may be handled. With channel enforcement, a request to
+// a channel that is not open is answered with an error.
+func (c *client) admitChannel(msg GenericMessage) bool {
+	channelID := messageChannel(msg)
+	if channelID == "" {
+		return true
+	}
+
+	err := c.channels.touch(channelID, time.Now(), true)
+	if err == nil || !c.enforceChannels {
+		return true
+	}
+	err = fmt.Errorf("%w: '%s'", err, channelID)
+
+	req, ok := msg.(RequestMessage)
+	if !ok {
+		c.logger.WithError(err).Debug("Dropping message for channel that is not open")
+		return false
+	}
+	code := CodeUnknownChannel
+	if errors.Is(err, ErrChannelClosed) {
+		code = CodeChannelClosed
+	}
+	if sendErr := c.SendErrorToChannel(&req, ErrorResponse{Code: code, Message: err.Error()}); sendErr != nil {
+		c.logger.WithError(sendErr).Error("Failed to reject request for channel that is not open")
+	}
+	return false
+}
+
+// superviseChannels sends the heartbeats and expires idle channels until
+// the client is closed
+func (c *client) superviseChannels(ctx context.Context) {
+	config := c.channels.config
+	if config.IdleTimeout <= 0 && config.HeartbeatInterval <= 0 {
+		return
+	}
+
+	var heartbeat, expire <-chan time.Time
+	if config.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(config.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	if config.IdleTimeout > 0 {
+		ticker := time.NewTicker(max(config.IdleTimeout/4, time.Millisecond))
+		defer ticker.Stop()
+		expire = ticker.C
+	}
+
+	for {
+		select {
+		case <-heartbeat:
+			for _, id := range c.channels.ids() {
+				if err := c.SendEventToChannel(ActionHeartbeat, nil, id); err != nil {
+					c.logger.WithError(err).WithField("channel_id", id).Debug("Failed to send heartbeat")
+				}
+			}
+		case now := <-expire:
+			for _, id := range c.channels.idle(now.Add(-config.IdleTimeout)) {
+				c.logger.WithField("channel_id", id).Info("Closing idle channel")
+				if err := c.CloseChannel(id, ReasonIdleTimeout); err != nil {
+					c.logger.WithError(err).WithField("channel_id", id).Debug("Failed to close idle channel")
+				}
+			}
+		case <-c.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lifecycleRecorder collects the channel lifecycle callbacks
+type lifecycleRecorder struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (r *lifecycleRecorder) config() *ChannelConfig {
+	return &ChannelConfig{
+		OnOpen: func(ch Channel) {
+			r.record("open:" + ch.ID)
+		},
+		OnClose: func(ch Channel, reason string) {
+			r.record("close:" + ch.ID + ":" + reason)
+		},
+	}
+}
+
+func (r *lifecycleRecorder) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *lifecycleRecorder) wait(t *testing.T, want ...string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return assert.ObjectsAreEqual(want, r.events)
+	}, time.Second, 5*time.Millisecond, "want %v", want)
+}
+
+func TestClient_ChannelLifecycle(t *testing.T) {
+	t.Run("open and close are mirrored by the peer", func(t *testing.T) {
+		deviceEvents, apiEvents := &lifecycleRecorder{}, &lifecycleRecorder{}
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Channels: deviceEvents.config()},
+			ClientConfig{Source: SystemAPI, Channels: apiEvents.config()},
+		)
+
+		opened, err := api.OpenChannel("channel-1", map[string]string{"user": "pilot"})
+		require.NoError(t, err)
+		assert.Equal(t, SystemAPI, opened.Opener)
+		assert.False(t, opened.CreatedAt.IsZero())
+
+		deviceEvents.wait(t, "open:channel-1")
+		apiEvents.wait(t, "open:channel-1")
+
+		ch, ok := device.LookupChannel("channel-1")
+		require.True(t, ok)
+		assert.Equal(t, SystemAPI, ch.Opener)
+		assert.Equal(t, map[string]string{"user": "pilot"}, ch.Metadata)
+
+		_, err = api.OpenChannel("channel-1", nil)
+		assert.ErrorIs(t, err, ErrChannelOpen)
+
+		require.NoError(t, device.CloseChannel("channel-1", "landed"))
+		apiEvents.wait(t, "open:channel-1", "close:channel-1:landed")
+		deviceEvents.wait(t, "open:channel-1", "close:channel-1:landed")
+
+		assert.Empty(t, api.Channels())
+		assert.ErrorIs(t, api.CloseChannel("channel-1", ""), ErrUnknownChannel)
+	})
+
+	t.Run("tracks activity", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(nil)
+		})
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router, Channels: &ChannelConfig{}},
+			ClientConfig{Source: SystemAPI, Channels: &ChannelConfig{}},
+		)
+
+		opened, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(device.Channels()) == 1 }, time.Second, 5*time.Millisecond)
+		created := device.Channels()[0].LastActivity
+
+		time.Sleep(5 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = api.Call(ctx, "camera.zoom", nil, "channel-1")
+		require.NoError(t, err)
+
+		ch, ok := device.LookupChannel("channel-1")
+		require.True(t, ok)
+		assert.True(t, ch.LastActivity.After(created))
+
+		ch, ok = api.LookupChannel("channel-1")
+		require.True(t, ok)
+		assert.True(t, ch.LastActivity.After(opened.LastActivity))
+	})
+
+	t.Run("rejects requests to unknown and closed channels", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(nil)
+		})
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router, Channels: &ChannelConfig{}},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := api.Call(ctx, "camera.zoom", nil, "channel-1")
+		var errResp *ErrorResponse
+		require.ErrorAs(t, err, &errResp)
+		assert.Equal(t, CodeUnknownChannel, errResp.Code)
+
+		_, err = api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(device.Channels()) == 1 }, time.Second, 5*time.Millisecond)
+		_, err = api.Call(ctx, "camera.zoom", nil, "channel-1")
+		require.NoError(t, err)
+
+		require.NoError(t, api.CloseChannel("channel-1", ""))
+		require.Eventually(t, func() bool { return len(device.Channels()) == 0 }, time.Second, 5*time.Millisecond)
+		_, err = api.Call(ctx, "camera.zoom", nil, "channel-1")
+		require.ErrorAs(t, err, &errResp)
+		assert.Equal(t, CodeChannelClosed, errResp.Code)
+
+		// Messages without a channel are not affected.
+		_, err = api.Call(ctx, "camera.zoom", nil, "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("drops events to unknown channels", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Channels: &ChannelConfig{}})
+		defer cancel()
+
+		pushEvent(conn, "telemetry", SystemDevice, "channel-1")
+		pushMessage(conn, map[string]any{"type": TypeChannelOpen, "channel_id": "channel-2", "source": SystemDevice})
+		pushEvent(conn, "armed", SystemDevice, "channel-2")
+
+		assert.Equal(t, []string{"armed"}, readN(t, api, 1))
+	})
+
+	t.Run("without enforcement messages are accepted", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		pushEvent(conn, "telemetry", SystemDevice, "channel-1")
+		assert.Equal(t, []string{"telemetry"}, readN(t, api, 1))
+	})
+
+	t.Run("without enforcement unopened channels cost no allocations", func(t *testing.T) {
+		impl := NewClient(logrus.NewEntry(logrus.New()), NewMockConnection(), ClientConfig{Source: SystemAPI}).(*client)
+		var msg GenericMessage = EventMessage{Action: "telemetry", ChannelID: "channel-1"}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			impl.admitChannel(msg)
+		})
+		assert.Zero(t, allocs)
+	})
+
+	t.Run("close cancels in-flight requests", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		_, err = api.OpenChannel("channel-2", nil)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(device.Channels()) == 2 }, time.Second, 5*time.Millisecond)
+
+		go func() { _, _ = api.Call(context.Background(), "camera.record", nil, "channel-1") }()
+		first := waitContext(t, contexts)
+		go func() { _, _ = api.Call(context.Background(), "camera.record", nil, "channel-2") }()
+		second := waitContext(t, contexts)
+
+		require.NoError(t, api.CloseChannel("channel-1", "done"))
+		assertCancelled(t, first)
+		assertNotCancelled(t, second)
+	})
+
+	t.Run("inbound middlewares see open and close", func(t *testing.T) {
+		var seen atomic.Int32
+		denyAll := func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				seen.Add(1)
+				return nil
+			}
+		}
+		events := &lifecycleRecorder{}
+		conn := NewMockConnection()
+		captureSent(conn)
+		device, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Channels: events.config(), Inbound: []Middleware{denyAll}})
+		defer cancel()
+
+		_, err := device.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		pushMessage(conn, map[string]any{"type": TypeChannelClose, "channel_id": "channel-1", "source": SystemAPI})
+		pushMessage(conn, map[string]any{"type": TypeChannelOpen, "channel_id": "channel-2", "source": SystemAPI})
+
+		require.Eventually(t, func() bool { return seen.Load() == 2 }, time.Second, 5*time.Millisecond)
+		events.wait(t, "open:channel-1")
+		_, ok := device.LookupChannel("channel-1")
+		assert.True(t, ok)
+		_, ok = device.LookupChannel("channel-2")
+		assert.False(t, ok)
+	})
+
+	t.Run("client close closes every channel", func(t *testing.T) {
+		events := &lifecycleRecorder{}
+		conn := NewMockConnection()
+		captureSent(conn)
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Channels: events.config()})
+		defer cancel()
+
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		require.NoError(t, api.Close())
+
+		events.wait(t, "open:channel-1", "close:channel-1:client closed")
+		assert.Empty(t, api.Channels())
+	})
+}
+
+func TestParser_ChannelMessages(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type":"channel_open","channel_id":"channel-1","source":"api","metadata":{"user":"pilot"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, ChannelOpenMessage{ChannelID: "channel-1", Source: SystemAPI, Metadata: map[string]string{"user": "pilot"}}, msg)
+
+	msg, err = UnmarshalMessage([]byte(`{"type":"channel_close","channel_id":"channel-1","source":"device","reason":"landed"}`))
+	require.NoError(t, err)
+	assert.Equal(t, ChannelCloseMessage{ChannelID: "channel-1", Source: SystemDevice, Reason: "landed"}, msg)
+
+	_, err = UnmarshalMessage([]byte(`{"type":"channel_close","source":"device"}`))
+	assert.Error(t, err)
+}
+
+func TestClient_ChannelIdleTimeout(t *testing.T) {
+	t.Run("expires idle channels on both sides", func(t *testing.T) {
+		deviceEvents, apiEvents := &lifecycleRecorder{}, &lifecycleRecorder{}
+		deviceChannels := deviceEvents.config()
+		deviceChannels.IdleTimeout = 50 * time.Millisecond
+		router, contexts := capturingRouter("camera.record")
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router, Channels: deviceChannels},
+			ClientConfig{Source: SystemAPI, Channels: apiEvents.config()},
+		)
+
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		deviceEvents.wait(t, "open:channel-1")
+		view := device.Channel("channel-1")
+		go func() { _, _ = api.Call(context.Background(), "camera.record", nil, "channel-1") }()
+		ctx := waitContext(t, contexts)
+
+		assertCancelled(t, ctx)
+		closed := "close:channel-1:" + ReasonIdleTimeout
+		deviceEvents.wait(t, "open:channel-1", closed)
+		apiEvents.wait(t, "open:channel-1", closed)
+		assertInvalidated(t, view)
+		assert.Empty(t, device.Channels())
+		assert.Empty(t, api.Channels())
+	})
+
+	t.Run("fails outgoing calls on expired channels", func(t *testing.T) {
+		deviceEvents := &lifecycleRecorder{}
+		deviceChannels := deviceEvents.config()
+		deviceChannels.IdleTimeout = 50 * time.Millisecond
+		router := NewRouter()
+		router.Handle("upload.*", func(ctx context.Context, req *RequestMessage, res Responder) {})
+		device, _ := connectClients(t,
+			ClientConfig{Source: SystemDevice, Channels: deviceChannels},
+			ClientConfig{Source: SystemAPI, Router: router},
+		)
+
+		_, err := device.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		stream, err := device.CallStream(context.Background(), "upload.logs", nil, "channel-1")
+		require.NoError(t, err)
+		_, err = device.Call(context.Background(), "upload.photo", nil, "channel-1")
+		assert.ErrorIs(t, err, ErrChannelClosed)
+
+		for range stream.Responses() {
+		}
+		assert.ErrorIs(t, stream.Err(), ErrChannelClosed)
+		assert.Equal(t, 0, pendingCount(device))
+	})
+
+	t.Run("heartbeats keep channels open", func(t *testing.T) {
+		deviceEvents := &lifecycleRecorder{}
+		deviceChannels := deviceEvents.config()
+		deviceChannels.IdleTimeout = 50 * time.Millisecond
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Channels: deviceChannels},
+			ClientConfig{Source: SystemAPI, Channels: &ChannelConfig{HeartbeatInterval: 10 * time.Millisecond}},
+		)
+
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		deviceEvents.wait(t, "open:channel-1")
+
+		time.Sleep(200 * time.Millisecond)
+		ch, ok := device.LookupChannel("channel-1")
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now(), ch.LastReceived, 50*time.Millisecond)
+		deviceEvents.wait(t, "open:channel-1")
+
+		// Heartbeats never reach the application.
+		select {
+		case msg := <-device.ReadMessage():
+			t.Fatalf("unexpected message %v", msg)
+		default:
+		}
+	})
+
+	t.Run("outgoing traffic does not keep channels open", func(t *testing.T) {
+		deviceEvents := &lifecycleRecorder{}
+		deviceChannels := deviceEvents.config()
+		deviceChannels.IdleTimeout = 50 * time.Millisecond
+		deviceChannels.HeartbeatInterval = 10 * time.Millisecond
+		device, _ := connectClients(t,
+			ClientConfig{Source: SystemDevice, Channels: deviceChannels},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		_, err := device.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		deviceEvents.wait(t, "open:channel-1", "close:channel-1:"+ReasonIdleTimeout)
+	})
+}
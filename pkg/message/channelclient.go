@@ -0,0 +1,251 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ChannelClient is a view of a client scoped to one channel. It stops
+// working once the channel is closed by either side or the client is closed.
+type ChannelClient interface {
+	// ID returns the channel of the view
+	ID() ChannelID
+
+	// Emit sends an event on the channel
+	Emit(action MessageAction, payload any) error
+
+	// Call sends a request on the channel and waits for the matching
+	// response. It fails with ErrChannelClosed if the channel closes first.
+	Call(ctx context.Context, action MessageAction, payload any, opts ...CallOption) (*ResponseMessage, error)
+
+	// Reply answers a request received on the channel
+	Reply(req *RequestMessage, payload any) error
+
+	// Fail answers a request received on the channel with an error
+	Fail(req *RequestMessage, errResponse ErrorResponse) error
+
+	// Messages returns the incoming messages of the channel that are not
+	// consumed by the router, a subscription or a pending call. Once it has
+	// been called those messages no longer reach ReadMessage. The channel is
+	// closed when the view is invalidated.
+	Messages() <-chan GenericMessage
+
+	// Done is closed when the view is invalidated
+	Done() <-chan struct{}
+
+	// Err returns ErrChannelClosed once the view is invalidated, or
+	// ErrUnknownChannel for a view of a channel that was not open, nil before
+	Err() error
+}
+
+// channelClient implements ChannelClient
+type channelClient struct {
+	client *client
+	id     ChannelID
+	done   chan struct{}
+
+	mutex    sync.Mutex
+	closed   bool
+	err      error
+	messages chan GenericMessage
+}
+
+// Channel returns the view of a channel. Views of an open channel are
+// shared: every call returns the same view until the channel closes. The
+// view of a channel that is not open is already invalid.
+func (c *client) Channel(id ChannelID) ChannelClient {
+	c.viewsMutex.Lock()
+	defer c.viewsMutex.Unlock()
+
+	if v, ok := c.views[id]; ok {
+		return v
+	}
+
+	select {
+	case <-c.done:
+		return invalidView(c, id, nil)
+	default:
+	}
+	switch err := c.channels.check(id); {
+	case err == nil:
+		v := &channelClient{client: c, id: id, done: make(chan struct{})}
+		c.views[id] = v
+		return v
+	case errors.Is(err, ErrChannelClosed):
+		return invalidView(c, id, nil)
+	default:
+		return invalidView(c, id, fmt.Errorf("%w: '%s'", ErrUnknownChannel, id))
+	}
+}
+
+// invalidView returns a view that is already invalid, failing with err
+// instead of ErrChannelClosed when set
+func invalidView(c *client, id ChannelID, err error) *channelClient {
+	v := &channelClient{client: c, id: id, done: make(chan struct{}), err: err}
+	v.invalidate()
+	return v
+}
+
+// invalidateView invalidates the view of a closed channel
+func (c *client) invalidateView(id ChannelID) {
+	c.viewsMutex.Lock()
+	v, ok := c.views[id]
+	delete(c.views, id)
+	c.viewsMutex.Unlock()
+
+	if ok {
+		v.invalidate()
+	}
+}
+
+// invalidateViews invalidates every view when the client is closed
+func (c *client) invalidateViews() {
+	c.viewsMutex.Lock()
+	views := c.views
+	c.views = make(map[ChannelID]*channelClient)
+	c.viewsMutex.Unlock()
+
+	for _, v := range views {
+		v.invalidate()
+	}
+}
+
+// deliverToChannel hands a message to the stream of its channel view and
+// reports whether there was one
+func (c *client) deliverToChannel(msg GenericMessage) bool {
+	id := messageChannel(msg)
+	if id == "" {
+		return false
+	}
+
+	c.viewsMutex.Lock()
+	v, ok := c.views[id]
+	c.viewsMutex.Unlock()
+
+	return ok && v.deliver(msg)
+}
+
+func (v *channelClient) ID() ChannelID {
+	return v.id
+}
+
+func (v *channelClient) Emit(action MessageAction, payload any) error {
+	if err := v.Err(); err != nil {
+		return err
+	}
+	return v.client.SendEventToChannel(action, payload, v.id)
+}
+
+func (v *channelClient) Call(ctx context.Context, action MessageAction, payload any, opts ...CallOption) (*ResponseMessage, error) {
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	// Closing the channel abandons the call.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		select {
+		case <-v.done:
+			cancel(v.Err())
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := v.client.Call(ctx, action, payload, v.id, opts...)
+	if err != nil && errors.Is(context.Cause(ctx), ErrChannelClosed) {
+		return nil, context.Cause(ctx)
+	}
+	return resp, err
+}
+
+func (v *channelClient) Reply(req *RequestMessage, payload any) error {
+	if err := v.check(req); err != nil {
+		return err
+	}
+	return v.client.SendResponse(req, payload)
+}
+
+func (v *channelClient) Fail(req *RequestMessage, errResponse ErrorResponse) error {
+	if err := v.check(req); err != nil {
+		return err
+	}
+	return v.client.SendErrorToChannel(req, errResponse)
+}
+
+func (v *channelClient) Messages() <-chan GenericMessage {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.messages == nil {
+		v.messages = make(chan GenericMessage, DefaultSubscriptionBufferSize)
+		if v.closed {
+			close(v.messages)
+		}
+	}
+	return v.messages
+}
+
+func (v *channelClient) Done() <-chan struct{} {
+	return v.done
+}
+
+func (v *channelClient) Err() error {
+	select {
+	case <-v.done:
+		if v.err != nil {
+			return v.err
+		}
+		return fmt.Errorf("%w: '%s'", ErrChannelClosed, v.id)
+	default:
+		return nil
+	}
+}
+
+// check verifies that the view is valid and the request belongs to its channel
+func (v *channelClient) check(req *RequestMessage) error {
+	if err := v.Err(); err != nil {
+		return err
+	}
+	if req.ChannelID != v.id {
+		return fmt.Errorf("request '%s' belongs to channel '%s', not '%s'", req.RequestID, req.ChannelID, v.id)
+	}
+	return nil
+}
+
+// deliver queues a message on the stream, dropping it when the stream is
+// full. It reports whether the view has a stream.
+func (v *channelClient) deliver(msg GenericMessage) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.closed || v.messages == nil {
+		return false
+	}
+	select {
+	case v.messages <- msg:
+		if v.client.printConfig != nil {
+			Print(msg, v.client.printConfig)
+		}
+	default:
+		v.client.drop(msg)
+	}
+	return true
+}
+
+// invalidate closes the view and its stream
+func (v *channelClient) invalidate() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.closed {
+		return
+	}
+	v.closed = true
+	close(v.done)
+	if v.messages != nil {
+		close(v.messages)
+	}
+}
@@ -0,0 +1,189 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertInvalidated(t *testing.T, view ChannelClient) {
+	t.Helper()
+	select {
+	case <-view.Done():
+		assert.ErrorIs(t, view.Err(), ErrChannelClosed)
+	case <-time.After(time.Second):
+		t.Fatal("channel view was not invalidated")
+	}
+}
+
+func TestClient_Channel(t *testing.T) {
+	t.Run("scopes messages to the channel", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(req.ChannelID)
+		})
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		view := api.Channel("channel-1")
+		assert.Equal(t, "channel-1", view.ID())
+		assert.Same(t, view, api.Channel("channel-1"))
+		messages := view.Messages()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := view.Call(ctx, "camera.zoom", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "channel-1", resp.Payload)
+
+		require.NoError(t, device.SendEventToChannel("telemetry", nil, "channel-2"))
+		require.NoError(t, device.SendEventToChannel("armed", nil, "channel-1"))
+
+		select {
+		case msg := <-messages:
+			event, ok := msg.(EventMessage)
+			require.True(t, ok)
+			assert.Equal(t, "armed", event.Action)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for channel message")
+		}
+		assert.Equal(t, []string{"telemetry"}, readN(t, api, 1))
+	})
+
+	t.Run("emits and replies on the channel", func(t *testing.T) {
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice},
+			ClientConfig{Source: SystemAPI},
+		)
+		_, err := device.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		view := device.Channel("channel-1")
+		messages := view.Messages()
+
+		require.NoError(t, view.Emit("armed", map[string]any{"armed": true}))
+		select {
+		case msg := <-api.ReadMessage():
+			event, ok := msg.(EventMessage)
+			require.True(t, ok)
+			assert.Equal(t, "channel-1", event.ChannelID)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+
+		results := make(chan error, 2)
+		go func() {
+			_, err := api.Call(context.Background(), "camera.zoom", nil, "channel-1")
+			results <- err
+		}()
+		go func() {
+			_, err := api.Call(context.Background(), "camera.focus", nil, "channel-1")
+			results <- err
+		}()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case msg := <-messages:
+				req := msg.(RequestMessage)
+				if req.Action == "camera.zoom" {
+					require.NoError(t, view.Reply(&req, nil))
+				} else {
+					require.NoError(t, view.Fail(&req, ErrorResponse{Code: "busy", Message: "camera is busy"}))
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for request")
+			}
+		}
+
+		var errs []error
+		for i := 0; i < 2; i++ {
+			errs = append(errs, <-results)
+		}
+		assert.Contains(t, errs, nil)
+		assert.Contains(t, errs, error(&ErrorResponse{Code: "busy", Message: "camera is busy"}))
+
+		other := RequestMessage{Action: "camera.zoom", RequestID: "req-1", ChannelID: "channel-2"}
+		assert.Error(t, view.Reply(&other, nil))
+	})
+
+	t.Run("invalidated when the channel closes", func(t *testing.T) {
+		router, contexts := capturingRouter("camera.record")
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		_, err := device.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(api.Channels()) == 1 }, time.Second, 5*time.Millisecond)
+
+		view := api.Channel("channel-1")
+		messages := view.Messages()
+		calls := make(chan error, 1)
+		go func() {
+			_, err := view.Call(context.Background(), "camera.record", nil)
+			calls <- err
+		}()
+		waitContext(t, contexts)
+
+		require.NoError(t, device.CloseChannel("channel-1", "landed"))
+		assertInvalidated(t, view)
+
+		select {
+		case err := <-calls:
+			assert.ErrorIs(t, err, ErrChannelClosed)
+		case <-time.After(time.Second):
+			t.Fatal("call was not abandoned")
+		}
+		_, open := <-messages
+		assert.False(t, open)
+		assert.ErrorIs(t, view.Emit("armed", nil), ErrChannelClosed)
+
+		// A closed channel only has invalid views.
+		assertInvalidated(t, api.Channel("channel-1"))
+	})
+
+	t.Run("views of unopened channels are invalid", func(t *testing.T) {
+		conn := NewMockConnection()
+		captureSent(conn)
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		for i := 0; i < 1000; i++ {
+			view := api.Channel(fmt.Sprintf("session-%d", i))
+			assert.ErrorIs(t, view.Err(), ErrUnknownChannel)
+		}
+		impl := api.(*client)
+		assert.Empty(t, impl.views)
+
+		view := api.Channel("session-1")
+		<-view.Done()
+		assert.ErrorIs(t, view.Emit("armed", nil), ErrUnknownChannel)
+		_, open := <-view.Messages()
+		assert.False(t, open)
+	})
+
+	t.Run("invalidated when the client closes", func(t *testing.T) {
+		_, api := connectClients(t,
+			ClientConfig{Source: SystemDevice},
+			ClientConfig{Source: SystemAPI},
+		)
+		_, err := api.OpenChannel("channel-1", nil)
+		require.NoError(t, err)
+		view := api.Channel("channel-1")
+
+		require.NoError(t, api.Close())
+		assertInvalidated(t, view)
+		assertInvalidated(t, api.Channel("channel-2"))
+
+		_, open := <-view.Messages()
+		assert.False(t, open)
+	})
+}
@@ -3,9 +3,12 @@ package message
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -45,6 +48,44 @@ type Client interface {
 	SendErrorToChannel(req *RequestMessage, payload ErrorResponse) error
 
 	SendEventToChannel(action MessageAction, payload any, sessionID ChannelID) error
+
+	// Call sends a request and waits for the matching response
+	Call(ctx context.Context, action MessageAction, payload any, channelID ChannelID, opts ...CallOption) (*ResponseMessage, error)
+
+	// CallBatch sends several requests in one frame and waits for their replies
+	CallBatch(ctx context.Context, calls []BatchCall, channelID ChannelID) ([]BatchResult, error)
+
+	// CallStream sends a request and returns the stream of partial responses
+	CallStream(ctx context.Context, action MessageAction, payload any, channelID ChannelID, opts ...CallOption) (*Stream, error)
+
+	// Subscribe delivers the incoming events matching the filter to a new
+	// subscription instead of ReadMessage
+	Subscribe(filter EventFilter, opts ...SubscribeOption) (Subscription, error)
+
+	// OpenChannel opens a channel and announces it to the peer
+	OpenChannel(id ChannelID, metadata map[string]string) (Channel, error)
+
+	// CloseChannel closes a channel, cancelling the requests being handled
+	// on it and failing the calls made on it, and tells the peer
+	CloseChannel(id ChannelID, reason string) error
+
+	// Channel returns a view of the client scoped to one channel
+	Channel(id ChannelID) ChannelClient
+
+	// Channels returns the open channels, oldest first
+	Channels() []Channel
+
+	// LookupChannel returns an open channel
+	LookupChannel(id ChannelID) (Channel, bool)
+
+	// Stats returns a snapshot of the client counters
+	Stats() ClientStats
+
+	// Negotiation returns the outcome of the handshake, nil until it completes
+	Negotiation() *Negotiation
+
+	// AwaitNegotiation blocks until the handshake completes
+	AwaitNegotiation(ctx context.Context) (*Negotiation, error)
 }
 
 // Connection represents a WebSocket connection
@@ -58,6 +99,60 @@ type Connection interface {
 type ClientConfig struct {
 	Source      MessageSource
 	PrintConfig *PrintConfig
+
+	// Router receives incoming requests and events instead of ReadMessage
+	Router *Router
+
+	// Inbound middlewares run, in order, on every parsed incoming message
+	// but the hello and welcome of the handshake
+	Inbound []Middleware
+
+	// Outbound middlewares run, in order, on every message passed to Send
+	Outbound []Middleware
+
+	// BufferSize is the capacity of the ReadMessage channel, DefaultBufferSize if zero
+	BufferSize int
+
+	// Overflow decides what happens to incoming messages when the ReadMessage channel is full
+	Overflow OverflowPolicy
+
+	// OnDrop is called with every message discarded by the overflow policy
+	OnDrop func(msg GenericMessage)
+
+	// Codec encodes and decodes messages. When nil, the codec declared by
+	// the connection is used, falling back to JSONCodec.
+	Codec Codec
+
+	// Handshake configures the hello/welcome handshake
+	Handshake *HandshakeConfig
+
+	// Strict rejects incoming messages without a timestamp
+	Strict bool
+
+	// Dedup suppresses redelivered requests when set
+	Dedup *DedupConfig
+
+	// Retry holds the retry policy of Call per action
+	Retry map[MessageAction]RetryPolicy
+
+	// RequestIdleTimeout bounds how long a request without a timeout stays
+	// tracked once its handler returned without a final reply. Every reply
+	// sent for it restarts the wait; when it passes, the handler context is
+	// cancelled. DefaultRequestIdleTimeout if zero.
+	RequestIdleTimeout time.Duration
+
+	// RequestIDGenerator creates the IDs of requests sent by the client,
+	// UUIDv7 if nil
+	RequestIDGenerator RequestIDGenerator
+
+	// Dispatcher runs the router and subscription handlers on a worker pool
+	// sharded by channel when set. Otherwise they run on the Listen goroutine.
+	// It requires a Router.
+	Dispatcher *DispatcherConfig
+
+	// Channels refuses messages addressed to channels that are not open
+	// when set. Channels are tracked either way.
+	Channels *ChannelConfig
 }
 
 // client implements the Client interface
@@ -68,22 +163,112 @@ type client struct {
 	closed      bool
 	closeMutex  sync.Mutex
 	closeOnce   sync.Once
+	done        chan struct{}
 	source      MessageSource
 	printConfig *PrintConfig
+	strict      bool
+	codec       atomic.Pointer[Codec]
+	baseCodec   Codec
+	handshake   *handshake
+	router      *Router
+	dispatcher  *Dispatcher
+	inbound     MessageHandler
+	outbound    MessageHandler
+
+	subscriptionsMutex sync.RWMutex
+	subscriptions      []*subscription
+
+	channels        *channelManager
+	enforceChannels bool
+	viewsMutex      sync.Mutex
+	views           map[ChannelID]*channelClient
+
+	overflow     OverflowPolicy
+	onDrop       func(msg GenericMessage)
+	forwardMutex sync.RWMutex
+	shedMutex    sync.Mutex
+
+	batchMutex    sync.Mutex
+	batches       map[inflightKey]*batchReplies
+	dedup         *dedupCache
+	retry         map[MessageAction]RetryPolicy
+	newRequestID  RequestIDGenerator
+	panics        atomic.Uint64
+	inflightMutex sync.Mutex
+	inflight      map[inflightKey]*inflightRequest
+	inflightIdle  time.Duration
+	expired       map[inflightKey]struct{}
+	expiredOrder  []inflightKey
+
+	pendingMutex   sync.Mutex
+	pending        map[RequestID]*pendingCall
+	abandoned      map[RequestID]struct{}
+	abandonedOrder []RequestID
 }
 
 // NewClient creates a new message client
 func NewClient(logger *log.Entry, conn Connection, config ClientConfig) Client {
-	return &client{
-		conn:        conn,
-		msgCh:       make(chan GenericMessage, 10000), // Much larger buffer for high throughput
-		logger:      logger.WithField("component", "message_client"),
-		closed:      false,
-		closeMutex:  sync.Mutex{},
-		closeOnce:   sync.Once{},
-		source:      config.Source,
-		printConfig: config.PrintConfig,
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize // Much larger buffer for high throughput
+	}
+
+	codec := config.Codec
+	if codec == nil {
+		if declarer, ok := conn.(CodecDeclarer); ok {
+			codec = declarer.Codec()
+		}
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	inflightIdle := config.RequestIdleTimeout
+	if inflightIdle <= 0 {
+		inflightIdle = DefaultRequestIdleTimeout
+	}
+
+	newRequestID := config.RequestIDGenerator
+	if newRequestID == nil {
+		newRequestID = UUIDv7
 	}
+
+	c := &client{
+		conn:            conn,
+		msgCh:           make(chan GenericMessage, bufferSize),
+		logger:          logger.WithField("component", "message_client"),
+		closed:          false,
+		closeMutex:      sync.Mutex{},
+		closeOnce:       sync.Once{},
+		done:            make(chan struct{}),
+		source:          config.Source,
+		printConfig:     config.PrintConfig,
+		strict:          config.Strict,
+		handshake:       newHandshake(config.Handshake),
+		router:          config.Router,
+		overflow:        config.Overflow,
+		onDrop:          config.OnDrop,
+		pending:         make(map[RequestID]*pendingCall),
+		abandoned:       make(map[RequestID]struct{}),
+		inflight:        make(map[inflightKey]*inflightRequest),
+		inflightIdle:    inflightIdle,
+		expired:         make(map[inflightKey]struct{}),
+		batches:         make(map[inflightKey]*batchReplies),
+		dedup:           newDedupCache(config.Dedup),
+		retry:           config.Retry,
+		newRequestID:    newRequestID,
+		channels:        newChannelManager(config.Channels),
+		enforceChannels: config.Channels != nil,
+		views:           make(map[ChannelID]*channelClient),
+	}
+	c.baseCodec = codec
+	c.setCodec(codec)
+	if config.Router != nil && config.Dispatcher != nil {
+		c.dispatcher = c.newDispatcher(*config.Dispatcher)
+	}
+	c.inbound = Chain(config.Inbound...)(c.dispatch)
+	c.outbound = Chain(config.Outbound...)(c.write)
+	return c
 }
 
 // Listen starts listening for incoming websocket messages and parses them
@@ -106,6 +291,15 @@ func (c *client) Listen(ctx context.Context) error {
 	// Get the message channel once instead of calling ReadMessage() in the loop
 	msgChan := c.conn.ReadMessage()
 
+	// A redialed link leads to a new peer session with its own handshake.
+	if notifier, ok := c.conn.(ReconnectNotifier); ok {
+		notifier.NotifyReconnect(c.restartHandshake)
+	}
+	if c.handshake.config.Initiate {
+		c.sendHello()
+	}
+	go c.superviseChannels(ctx)
+
 	// Process incoming messages until the connection is closed.
 	for {
 		select {
@@ -120,28 +314,14 @@ func (c *client) Listen(ctx context.Context) error {
 			}
 
 			// Parse the raw message.
-			msg, err := UnmarshalMessage(msgBytes)
+			msg, err := c.decode(msgBytes)
 			if err != nil {
 				c.logger.WithError(err).Error("Failed to parse message")
 				// Continue listening, even if a parse error occurs.
 				continue
 			}
 
-			// Forward the message if not closed.
-			if !c.IsClosed() {
-				select {
-				case c.msgCh <- msg:
-					// Only log trace if enabled to reduce overhead
-					if c.logger.Logger.IsLevelEnabled(log.TraceLevel) {
-						c.logger.Trace("Message received and forwarded")
-					}
-					if c.printConfig != nil {
-						Print(msg, c.printConfig)
-					}
-				default:
-					c.logger.Warn("GenericMessage channel full, dropping message")
-				}
-			}
+			c.receive(ctx, msg)
 
 		case <-ctx.Done():
 			if c.logger.Logger.IsLevelEnabled(log.TraceLevel) {
@@ -153,6 +333,161 @@ func (c *client) Listen(ctx context.Context) error {
 	}
 }
 
+// receive handles a parsed incoming message
+func (c *client) receive(ctx context.Context, msg GenericMessage) {
+	// A batch is handled message by message.
+	if batch, ok := msg.(BatchMessage); ok {
+		c.receiveBatch(ctx, batch)
+		return
+	}
+
+	// The handshake is handled by the client itself, ahead of the middlewares.
+	if c.handleHandshake(msg) {
+		return
+	}
+
+	// Run the message through the inbound middlewares.
+	if err := c.inbound(ctx, msg); err != nil {
+		c.rejectInbound(msg, err)
+	}
+}
+
+// decode parses an incoming frame. JSON frames are always accepted so that
+// messages sent before a codec switch are still understood.
+func (c *client) decode(data []byte) (any, error) {
+	codec := c.currentCodec()
+	if codec.Name() != CodecJSON && len(data) > 0 && data[0] == '{' {
+		codec = JSONCodec
+	}
+	return UnmarshalMessageWithOptions(data, ParseOptions{Codec: codec, Strict: c.strict})
+}
+
+// currentCodec returns the codec used on the connection
+func (c *client) currentCodec() Codec {
+	return *c.codec.Load()
+}
+
+// setCodec switches the codec used on the connection
+func (c *client) setCodec(codec Codec) {
+	c.codec.Store(&codec)
+	if switcher, ok := c.conn.(CodecSwitcher); ok {
+		switcher.SetCodec(codec)
+	}
+}
+
+// handleHandshake processes the hello and welcome messages and reports
+// whether the message was one
+func (c *client) handleHandshake(msg GenericMessage) bool {
+	switch m := msg.(type) {
+	case HelloMessage:
+		c.handleHello(m)
+	case WelcomeMessage:
+		c.handleWelcome(m)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleControl processes the protocol control messages that passed the
+// inbound middlewares and reports whether the message was one
+func (c *client) handleControl(msg GenericMessage) bool {
+	switch m := msg.(type) {
+	case CancelMessage:
+		c.handleCancel(m)
+	case ChannelOpenMessage:
+		c.handleChannelOpen(m)
+	case ChannelCloseMessage:
+		c.handleChannelClose(m)
+	default:
+		return false
+	}
+	return true
+}
+
+// dispatch is the final inbound handler: it delivers replies to pending
+// calls, routes requests and events, and forwards everything else to the
+// message channel.
+func (c *client) dispatch(ctx context.Context, msg GenericMessage) error {
+	// Protocol control messages are handled by the client itself.
+	if c.handleControl(msg) {
+		return nil
+	}
+
+	// Replies and progress for calls made with Call never reach the message channel.
+	if c.deliverProgress(msg) || c.deliverReply(msg) {
+		return nil
+	}
+
+	// Messages addressed to channels that are not open may be refused.
+	if !c.admitChannel(msg) {
+		return nil
+	}
+
+	// Heartbeats only keep their channel alive.
+	if event, ok := msg.(EventMessage); ok && event.Action == ActionHeartbeat {
+		return nil
+	}
+
+	// Redelivered requests are answered from the dedup cache.
+	if req, ok := msg.(RequestMessage); ok && c.dedup != nil && c.deduplicate(req) {
+		return nil
+	}
+
+	// Routed requests get a context cancelled by a cancel message.
+	if req, ok := msg.(RequestMessage); ok && c.router != nil {
+		ctx = c.trackRequest(ctx, req)
+	}
+
+	// With a dispatcher the handlers run on its workers. A batch is held
+	// until its messages are handled so that their replies are coalesced.
+	if c.dispatcher != nil {
+		c.holdBatch(ctx)
+		_ = c.dispatcher.Submit(ctx, msg)
+		return nil
+	}
+
+	c.handle(ctx, msg)
+	return nil
+}
+
+// handle delivers a message to the subscriptions, the router or the stream
+// of its channel view, and forwards it to the ReadMessage channel when none
+// consumed it
+func (c *client) handle(ctx context.Context, msg GenericMessage) {
+	if req, ok := msg.(RequestMessage); ok {
+		defer c.idleRequest(ctx, req)
+	}
+
+	subscribed := c.publish(ctx, msg)
+
+	// Requests and events with a registered handler are dispatched by the router.
+	if c.router != nil && c.route(ctx, msg) || subscribed {
+		return
+	}
+
+	// Messages of a channel whose view has a stream are delivered there.
+	if c.deliverToChannel(msg) {
+		return
+	}
+
+	// Forward the message to the ReadMessage channel.
+	c.forward(ctx, msg)
+}
+
+// rejectInbound answers a request refused by an inbound middleware with an
+// error message. Other rejected messages are only logged.
+func (c *client) rejectInbound(msg GenericMessage, err error) {
+	req, ok := msg.(RequestMessage)
+	if !ok {
+		c.logger.WithError(err).Debug("Inbound message rejected")
+		return
+	}
+	if sendErr := c.SendErrorToChannel(&req, ToErrorResponse(err)); sendErr != nil {
+		c.logger.WithError(sendErr).Error("Failed to send rejection")
+	}
+}
+
 // ReadMessage returns a channel of incoming messages.
 func (c *client) ReadMessage() <-chan GenericMessage {
 	return c.msgCh
@@ -161,31 +496,194 @@ func (c *client) ReadMessage() <-chan GenericMessage {
 // Send is a helper function that handles the common logic for sending messages
 func (c *client) Send(msg any, channelId *ChannelID) error {
 	if c.IsClosed() {
-		return fmt.Errorf("client connection is closed")
+		return ErrClientClosed
 	}
 
-	// First add channelId to the message if provided
-	if channelId != nil {
-		switch m := msg.(type) {
-		case RequestMessage:
+	msg = c.stamp(msg, channelId, time.Now().UnixMilli())
+
+	// Requests answered with deadline_exceeded get no other reply.
+	if err := c.checkExpired(msg); err != nil {
+		return err
+	}
+
+	// Run the message through the outbound middlewares.
+	if err := c.outbound(context.Background(), msg); err != nil {
+		return err
+	}
+
+	if channelID := messageChannel(msg); channelID != "" {
+		_ = c.channels.touch(channelID, time.Now(), false)
+	}
+
+	// Remember replies for deduplication; a final one completes the request.
+	switch m := msg.(type) {
+	case ResponseMessage:
+		if c.dedup != nil {
+			c.dedup.record(m.ChannelID, m.ReplyTo, m)
+		}
+		if isFinalReply(m) {
+			c.finishRequest(m.ChannelID, m.ReplyTo)
+		} else {
+			c.touchRequest(m.ChannelID, m.ReplyTo)
+		}
+	case ProgressMessage:
+		c.touchRequest(m.ChannelID, m.ReplyTo)
+	case ErrorMessage:
+		if c.dedup != nil {
+			c.dedup.record(m.ChannelID, m.ReplyTo, m)
+		}
+		c.finishRequest(m.ChannelID, m.ReplyTo)
+	}
+	return nil
+}
+
+// stamp adds the channelId if provided, defaults the source and request ID,
+// and sets the send time of an outgoing message
+func (c *client) stamp(msg any, channelId *ChannelID, now int64) any {
+	switch m := msg.(type) {
+	case RequestMessage:
+		if channelId != nil {
 			m.ChannelID = string(*channelId)
-			msg = m
-		case ResponseMessage:
+		}
+		if m.RequestID == "" {
+			m.RequestID = c.newRequestID()
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case ResponseMessage:
+		if channelId != nil {
 			m.ChannelID = *channelId
-			msg = m
-		case ErrorMessage:
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case ErrorMessage:
+		if channelId != nil {
+			m.ChannelID = *channelId
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case EventMessage:
+		if channelId != nil {
 			m.ChannelID = *channelId
-			msg = m
-		case EventMessage:
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case ProgressMessage:
+		if channelId != nil {
 			m.ChannelID = *channelId
-			msg = m
 		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case CancelMessage:
+		if channelId != nil {
+			m.ChannelID = *channelId
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case ChannelOpenMessage:
+		if channelId != nil {
+			m.ChannelID = *channelId
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case ChannelCloseMessage:
+		if channelId != nil {
+			m.ChannelID = *channelId
+		}
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		msg = m
+	case BatchMessage:
+		if m.Source == "" {
+			m.Source = c.source
+		}
+		messages := make([]GenericMessage, len(m.Messages))
+		for i, inner := range m.Messages {
+			messages[i] = c.stamp(inner, channelId, now)
+		}
+		m.Messages = messages
+		msg = m
 	}
+	return msg
+}
 
+// write is the final outbound handler: it encodes the message into its
+// envelope and writes it to the connection.
+func (c *client) write(_ context.Context, msg GenericMessage) error {
 	// Log the message we're about to send
 	Print(msg, c.printConfig)
 
-	// Prepare envelope based on the message type
+	// Replies to a batch being handled are sent together once it completes.
+	if c.collectBatchReply(msg) {
+		return nil
+	}
+	return c.writeFrame(msg)
+}
+
+// writeFrame encodes a message into its envelope and sends it as one frame
+func (c *client) writeFrame(msg GenericMessage) error {
+	envelope, err := newEnvelope(msg)
+	if err != nil {
+		return err
+	}
+
+	// Use pooled buffer for better performance
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if err := c.currentCodec().Encode(buf, envelope); err != nil {
+		c.logger.WithError(err).Error("Failed to marshal message")
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return c.conn.SendMessage(buf.Bytes())
+}
+
+// newEnvelope wraps a message in the envelope written to the wire, which
+// adds the message type
+func newEnvelope(msg GenericMessage) (any, error) {
 	var envelope any
 	switch m := msg.(type) {
 	case RequestMessage:
@@ -220,30 +718,80 @@ func (c *client) Send(msg any, channelId *ChannelID) error {
 			Type:         TypeEvent,
 			EventMessage: m,
 		}
+	case HelloMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			HelloMessage
+		}{
+			Type:         TypeHello,
+			HelloMessage: m,
+		}
+	case WelcomeMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			WelcomeMessage
+		}{
+			Type:           TypeWelcome,
+			WelcomeMessage: m,
+		}
+	case ProgressMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			ProgressMessage
+		}{
+			Type:            TypeProgress,
+			ProgressMessage: m,
+		}
+	case CancelMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			CancelMessage
+		}{
+			Type:          TypeCancel,
+			CancelMessage: m,
+		}
+	case ChannelOpenMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			ChannelOpenMessage
+		}{
+			Type:               TypeChannelOpen,
+			ChannelOpenMessage: m,
+		}
+	case ChannelCloseMessage:
+		envelope = struct {
+			Type string `json:"type"`
+			ChannelCloseMessage
+		}{
+			Type:                TypeChannelClose,
+			ChannelCloseMessage: m,
+		}
+	case BatchMessage:
+		messages := make([]any, len(m.Messages))
+		for i, inner := range m.Messages {
+			if _, nested := inner.(BatchMessage); nested {
+				return nil, errors.New("batch cannot contain a batch")
+			}
+			innerEnvelope, err := newEnvelope(inner)
+			if err != nil {
+				return nil, err
+			}
+			messages[i] = innerEnvelope
+		}
+		envelope = struct {
+			Type     string        `json:"type"`
+			Source   MessageSource `json:"source"`
+			Messages []any         `json:"messages"`
+		}{
+			Type:     TypeBatch,
+			Source:   m.Source,
+			Messages: messages,
+		}
 	default:
-		return fmt.Errorf("message type not supported: %T", msg)
+		return nil, fmt.Errorf("message type not supported: %T", msg)
 	}
+	return envelope, nil
 
-	// Use pooled buffer for better performance
-	buf := bufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		bufferPool.Put(buf)
-	}()
-
-	encoder := json.NewEncoder(buf)
-	if err := encoder.Encode(envelope); err != nil {
-		c.logger.WithError(err).Error("Failed to marshal message")
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
-
-	// Remove the trailing newline that Encoder adds
-	data := buf.Bytes()
-	if len(data) > 0 && data[len(data)-1] == '\n' {
-		data = data[:len(data)-1]
-	}
-
-	return c.conn.SendMessage(data)
 }
 
 // SendMessageToChannel sends a message to a specific session
@@ -262,6 +810,7 @@ func (c *client) SendResponse(req *RequestMessage, payload any) error {
 		Source:    c.source,
 		ChannelID: req.ChannelID,
 		ReplyTo:   req.RequestID,
+		Headers:   maps.Clone(req.Headers),
 	}, &req.ChannelID)
 }
 
@@ -281,22 +830,37 @@ func (c *client) SendErrorToChannel(req *RequestMessage, errResponse ErrorRespon
 		ChannelID: req.ChannelID,
 		Error:     errResponse,
 		ReplyTo:   req.RequestID,
+		Headers:   maps.Clone(req.Headers),
 	}, &req.ChannelID)
 }
 
 // Close safely closes the client connection.
 func (c *client) Close() error {
 	c.closeMutex.Lock()
-	defer c.closeMutex.Unlock()
-
 	if c.closed {
+		c.closeMutex.Unlock()
 		return nil
 	}
 	c.closed = true
 	c.closeOnce.Do(func() {
+		close(c.done)
+		// Wait for in-flight forwards before closing the channel they write to.
+		c.forwardMutex.Lock()
 		close(c.msgCh)
+		c.forwardMutex.Unlock()
+		c.closeSubscriptions()
 	})
-	return c.conn.Close()
+	c.failPendingCalls()
+	c.cancelAllRequests()
+	err := c.conn.Close()
+	c.closeMutex.Unlock()
+
+	// Drop and lifecycle callbacks may use the client, run them without the lock.
+	if c.dispatcher != nil {
+		c.dispatcher.Close()
+	}
+	c.closeChannels()
+	return err
 }
 
 // IsClosed returns whether the client is closed.
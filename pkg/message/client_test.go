@@ -616,3 +616,55 @@ func TestClient_ReadMessage(t *testing.T) {
 		// Expected
 	}
 }
+
+func TestClient_SendMetadata(t *testing.T) {
+	t.Run("stamps send time", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemDevice})
+
+		before := time.Now().UnixMilli()
+		require.NoError(t, client.Send(EventMessage{Action: "telemetry", Headers: map[string]string{"trace_id": "abc"}}, nil))
+
+		envelope := waitSent(t, sent)
+		assert.GreaterOrEqual(t, int64(envelope["timestamp"].(float64)), before)
+		assert.Equal(t, map[string]any{"trace_id": "abc"}, envelope["headers"])
+	})
+
+	t.Run("keeps explicit timestamp and message id", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemDevice})
+
+		require.NoError(t, client.Send(EventMessage{Action: "telemetry", MessageID: "msg-1", Timestamp: 42}, nil))
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, float64(42), envelope["timestamp"])
+		assert.Equal(t, "msg-1", envelope["message_id"])
+	})
+
+	t.Run("replies carry request headers", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemDevice})
+
+		req := &RequestMessage{Action: "camera.zoom", RequestID: "req-1", Headers: map[string]string{"trace_id": "abc"}}
+		require.NoError(t, client.SendResponse(req, nil))
+		require.NoError(t, client.SendErrorToChannel(req, ErrorResponse{Code: CodeInternal}))
+
+		for range 2 {
+			assert.Equal(t, map[string]any{"trace_id": "abc"}, waitSent(t, sent)["headers"])
+		}
+	})
+
+	t.Run("strict client drops messages without timestamp", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Strict: true})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "unstamped"})
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "stamped", "timestamp": 1})
+
+		assert.Equal(t, []string{"stamped"}, readN(t, client, 1))
+	})
+}
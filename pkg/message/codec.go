@@ -0,0 +1,88 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// Codec encodes message envelopes for the wire and decodes them back.
+// Implementations must honour the `json` struct tags of the message types.
+type Codec interface {
+	// Name identifies the codec, e.g. during negotiation
+	Name() string
+
+	// Encode appends the encoding of v to buf
+	Encode(buf *bytes.Buffer, v any) error
+
+	// Decode parses data into v
+	Decode(data []byte, v any) error
+}
+
+// CodecDeclarer is implemented by connections that dictate the codec used
+// on them, e.g. from a negotiated WebSocket subprotocol
+type CodecDeclarer interface {
+	Codec() Codec
+}
+
+// CodecSwitcher is implemented by connections whose framing depends on the
+// codec, e.g. WebSocket text and binary frames. The client tells them when
+// the handshake switches to another codec.
+type CodecSwitcher interface {
+	SetCodec(codec Codec)
+}
+
+// Codec names
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+	CodecCBOR    = "cbor"
+)
+
+// JSONCodec is the default codec
+var JSONCodec Codec = jsonCodec{}
+
+// jsonCodec implements Codec with encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (jsonCodec) Encode(buf *bytes.Buffer, v any) error {
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	// Remove the trailing newline that Encoder adds
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func (jsonCodec) Decode(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// codecs holds the codecs that can be looked up by name
+var (
+	codecsMutex sync.RWMutex
+	codecs      = map[string]Codec{
+		CodecJSON:    JSONCodec,
+		CodecMsgpack: MsgpackCodec,
+		CodecCBOR:    CBORCodec,
+	}
+)
+
+// RegisterCodec makes a codec available to LookupCodec under its name
+func RegisterCodec(codec Codec) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// LookupCodec returns the registered codec with the given name
+func LookupCodec(name string) (Codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	codec, ok := codecs[name]
+	return codec, ok
+}
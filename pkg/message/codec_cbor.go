@@ -0,0 +1,44 @@
+package message
+
+import (
+	"bytes"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORCodec encodes messages as CBOR
+var CBORCodec Codec = newCBORCodec()
+
+// cborCodec implements Codec with CBOR
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{
+		// Decode maps like encoding/json so payloads look the same for every codec
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) Name() string {
+	return CodecCBOR
+}
+
+func (c cborCodec) Encode(buf *bytes.Buffer, v any) error {
+	return c.enc.NewEncoder(buf).Encode(v)
+}
+
+func (c cborCodec) Decode(data []byte, v any) error {
+	return c.dec.Unmarshal(data, v)
+}
@@ -0,0 +1,36 @@
+package message
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackCodec encodes messages as MessagePack
+var MsgpackCodec Codec = msgpackCodec{}
+
+// msgpackCodec implements Codec with MessagePack
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return CodecMsgpack
+}
+
+func (msgpackCodec) Encode(buf *bytes.Buffer, v any) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+
+	enc.Reset(buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(data []byte, v any) error {
+	dec := msgpack.GetDecoder()
+	defer msgpack.PutDecoder(dec)
+
+	dec.Reset(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -0,0 +1,137 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var allCodecs = []Codec{JSONCodec, MsgpackCodec, CBORCodec}
+
+// declaringConnection is a mock connection that declares its codec
+type declaringConnection struct {
+	*MockConnection
+	codec Codec
+}
+
+func (d declaringConnection) Codec() Codec {
+	return d.codec
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	messages := []GenericMessage{
+		RequestMessage{Action: "camera.zoom", Source: SystemAPI, RequestID: "req-1", ChannelID: "channel-1", Payload: map[string]any{"level": 2}},
+		ResponseMessage{Action: "camera.zoom", Source: SystemDevice, ReplyTo: "req-1", Payload: []any{"a", "b"}},
+		ErrorMessage{Action: "camera.zoom", Source: SystemDevice, ReplyTo: "req-1", Error: ErrorResponse{Code: "busy", Message: "camera is busy"}},
+		EventMessage{Action: "telemetry", Source: SystemDevice, Payload: map[string]any{"battery": 0.5, "armed": true}},
+	}
+
+	for _, codec := range allCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			for _, msg := range messages {
+				conn := NewMockConnection()
+				var data []byte
+				conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+					data = bytes.Clone(args.Get(0).([]byte))
+				}).Return(nil)
+
+				client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Codec: codec})
+				require.NoError(t, client.Send(msg, nil))
+
+				decoded, err := UnmarshalMessageWithCodec(data, codec)
+				require.NoError(t, err)
+				assert.IsType(t, msg, decoded)
+
+				switch m := decoded.(type) {
+				case RequestMessage:
+					assert.Equal(t, "req-1", m.RequestID)
+					assert.Equal(t, "channel-1", m.ChannelID)
+					level, err := DecodePayload[map[string]int](m)
+					require.NoError(t, err)
+					assert.Equal(t, map[string]int{"level": 2}, level)
+				case ResponseMessage:
+					assert.Equal(t, "req-1", m.ReplyTo)
+					assert.Equal(t, []any{"a", "b"}, m.Payload)
+				case ErrorMessage:
+					assert.Equal(t, ErrorResponse{Code: "busy", Message: "camera is busy"}, m.Error)
+				case EventMessage:
+					assert.Equal(t, true, m.Payload.(map[string]any)["armed"])
+				}
+			}
+		})
+	}
+}
+
+func TestCodec_Validation(t *testing.T) {
+	for _, codec := range allCodecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, codec.Encode(&buf, map[string]any{"type": TypeRequest, "action": "camera.zoom"}))
+
+			_, err := UnmarshalMessageWithCodec(buf.Bytes(), codec)
+			assert.ErrorIs(t, err, ErrMissingRequestID)
+
+			_, err = UnmarshalMessageWithCodec([]byte{0xff, 0x00}, codec)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCodec_BinaryIsSmaller(t *testing.T) {
+	envelope := map[string]any{
+		"type":    TypeEvent,
+		"action":  "telemetry",
+		"payload": map[string]any{"lat": 50.4501, "lon": 30.5234, "alt": 120, "speed": 12, "armed": true},
+	}
+
+	var jsonBuf, msgpackBuf, cborBuf bytes.Buffer
+	require.NoError(t, JSONCodec.Encode(&jsonBuf, envelope))
+	require.NoError(t, MsgpackCodec.Encode(&msgpackBuf, envelope))
+	require.NoError(t, CBORCodec.Encode(&cborBuf, envelope))
+
+	assert.Less(t, msgpackBuf.Len(), jsonBuf.Len())
+	assert.Less(t, cborBuf.Len(), jsonBuf.Len())
+	assert.NotContains(t, jsonBuf.String(), "\n")
+}
+
+func TestLookupCodec(t *testing.T) {
+	for _, codec := range allCodecs {
+		found, ok := LookupCodec(codec.Name())
+		require.True(t, ok)
+		assert.Equal(t, codec.Name(), found.Name())
+	}
+
+	_, ok := LookupCodec("protobuf")
+	assert.False(t, ok)
+}
+
+func TestClient_DeclaredCodec(t *testing.T) {
+	mockConn := NewMockConnection()
+	conn := declaringConnection{MockConnection: mockConn, codec: MsgpackCodec}
+	mockConn.On("ReadMessage").Return()
+	mockConn.On("Close").Return(nil)
+
+	client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemAPI})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = client.Listen(ctx) }()
+
+	var buf bytes.Buffer
+	require.NoError(t, MsgpackCodec.Encode(&buf, map[string]any{"type": TypeEvent, "action": "telemetry"}))
+	mockConn.msgCh <- buf.Bytes()
+
+	select {
+	case msg := <-client.ReadMessage():
+		event, ok := msg.(EventMessage)
+		require.True(t, ok)
+		assert.Equal(t, "telemetry", event.Action)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
@@ -0,0 +1,169 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Dedup defaults
+const (
+	DefaultDedupTTL        = 5 * time.Minute
+	DefaultDedupMaxEntries = 10000
+)
+
+// DedupConfig enables deduplication of incoming requests. A request whose
+// (Source, ChannelID, RequestID) was already received is not executed again:
+// the replies sent for the first one are replayed instead, or nothing is
+// sent while the first one is still being handled. The only exception is a
+// request refused with CodeBusy or CodeOverloaded before any other reply:
+// its handler did no work, so it is forgotten and a retry executes it.
+type DedupConfig struct {
+	// TTL is how long a request is remembered, DefaultDedupTTL if zero
+	TTL time.Duration
+
+	// MaxEntries bounds the number of remembered requests, the oldest are
+	// forgotten first. DefaultDedupMaxEntries if zero.
+	MaxEntries int
+}
+
+// dedupKey identifies a request across redeliveries
+type dedupKey struct {
+	source    MessageSource
+	channelID ChannelID
+	requestID RequestID
+}
+
+// dedupEntry remembers a received request and the replies sent for it
+type dedupEntry struct {
+	key     dedupKey
+	expires time.Time
+	replies []GenericMessage
+	done    bool
+}
+
+// dedupCache is a bounded TTL cache of received requests
+type dedupCache struct {
+	config DedupConfig
+
+	mutex   sync.Mutex
+	entries map[dedupKey]*dedupEntry
+	order   []*dedupEntry
+
+	// replying indexes the unanswered entries by the key of their replies
+	replying map[inflightKey]*dedupEntry
+}
+
+func newDedupCache(config *DedupConfig) *dedupCache {
+	if config == nil {
+		return nil
+	}
+	d := &dedupCache{
+		config:   *config,
+		entries:  make(map[dedupKey]*dedupEntry),
+		replying: make(map[inflightKey]*dedupEntry),
+	}
+	if d.config.TTL <= 0 {
+		d.config.TTL = DefaultDedupTTL
+	}
+	if d.config.MaxEntries <= 0 {
+		d.config.MaxEntries = DefaultDedupMaxEntries
+	}
+	return d
+}
+
+// seen records req and reports whether it is a duplicate. For a duplicate
+// it returns the replies sent so far and whether the request was answered.
+func (d *dedupCache) seen(req RequestMessage) (replies []GenericMessage, done bool, duplicate bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	d.prune(now)
+
+	key := dedupKey{source: req.Source, channelID: req.ChannelID, requestID: req.RequestID}
+	if entry, ok := d.entries[key]; ok {
+		return append([]GenericMessage(nil), entry.replies...), entry.done, true
+	}
+
+	entry := &dedupEntry{key: key, expires: now.Add(d.config.TTL)}
+	d.entries[key] = entry
+	d.order = append(d.order, entry)
+	d.replying[inflightKey{channelID: req.ChannelID, requestID: req.RequestID}] = entry
+	return nil, false, false
+}
+
+// record remembers a reply sent for a request
+func (d *dedupCache) record(channelID ChannelID, replyTo RequestID, reply GenericMessage) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := inflightKey{channelID: channelID, requestID: replyTo}
+	entry, ok := d.replying[key]
+	if !ok {
+		return
+	}
+	if refused(reply) && len(entry.replies) == 0 {
+		d.forget(entry)
+		return
+	}
+	entry.replies = append(entry.replies, reply)
+	if isFinalReply(reply) {
+		entry.done = true
+		delete(d.replying, key)
+	}
+}
+
+// refused reports whether reply turns a request away without executing it.
+// Other errors, deadline_exceeded and internal included, may follow work
+// that must not be repeated and are replayed like any other reply.
+func refused(reply GenericMessage) bool {
+	errMsg, ok := reply.(ErrorMessage)
+	return ok && (errMsg.Error.Code == CodeBusy || errMsg.Error.Code == CodeOverloaded)
+}
+
+// forget drops an entry so that its request is executed again if redelivered
+func (d *dedupCache) forget(entry *dedupEntry) {
+	if d.entries[entry.key] == entry {
+		delete(d.entries, entry.key)
+	}
+	key := inflightKey{channelID: entry.key.channelID, requestID: entry.key.requestID}
+	if d.replying[key] == entry {
+		delete(d.replying, key)
+	}
+}
+
+// prune forgets expired entries and the oldest ones beyond MaxEntries
+func (d *dedupCache) prune(now time.Time) {
+	n := 0
+	for n < len(d.order) && (len(d.order)-n >= d.config.MaxEntries || now.After(d.order[n].expires)) {
+		entry := d.order[n]
+		d.forget(entry)
+		d.order[n] = nil
+		n++
+	}
+	d.order = d.order[n:]
+}
+
+// deduplicate reports whether req was already received and, if so, replays
+// the replies sent for it
+func (c *client) deduplicate(req RequestMessage) bool {
+	replies, done, duplicate := c.dedup.seen(req)
+	if !duplicate {
+		return false
+	}
+
+	logger := c.logger.WithField("action", req.Action).WithField("request_id", req.RequestID)
+	if !done {
+		logger.Debug("Suppressing duplicate of request still being handled")
+	} else {
+		logger.Debug("Replaying replies for duplicate request")
+	}
+	for _, reply := range replies {
+		if err := c.outbound(context.Background(), reply); err != nil {
+			logger.WithError(err).Warn("Failed to replay reply for duplicate request")
+			break
+		}
+	}
+	return true
+}
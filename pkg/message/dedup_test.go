@@ -0,0 +1,193 @@
+package message
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRouter answers every request with reply and counts executions
+func countingRouter(reply func(res Responder)) (*Router, *atomic.Int32) {
+	var executions atomic.Int32
+	router := NewRouter()
+	router.Handle("camera.shoot", func(ctx context.Context, req *RequestMessage, res Responder) {
+		executions.Add(1)
+		reply(res)
+	})
+	return router, &executions
+}
+
+func pushShoot(conn *MockConnection, source MessageSource, channelID ChannelID, requestID RequestID) {
+	pushMessage(conn, map[string]any{
+		"type":       TypeRequest,
+		"action":     "camera.shoot",
+		"source":     source,
+		"channel_id": channelID,
+		"request_id": requestID,
+	})
+}
+
+func assertNothingSent(t *testing.T, sent <-chan map[string]any) {
+	t.Helper()
+	select {
+	case envelope := <-sent:
+		t.Fatalf("unexpected message sent: %v", envelope)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestClient_Dedup(t *testing.T) {
+	t.Run("replays response for duplicate", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Reply("photo-1") })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		first := waitSent(t, sent)
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		second := waitSent(t, sent)
+
+		assert.Equal(t, int32(1), executions.Load())
+		assert.Equal(t, first, second)
+		assert.Equal(t, "photo-1", second["payload"])
+	})
+
+	t.Run("replays error and streamed replies", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("scan", func(ctx context.Context, req *RequestMessage, res Responder) {
+			stream := res.Stream()
+			_ = stream.Send("wifi-1")
+			_ = stream.Fail(ErrorResponse{Code: "radio_off"})
+		})
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+		defer cancel()
+
+		request := map[string]any{"type": TypeRequest, "action": "scan", "request_id": "req-1"}
+		pushMessage(conn, request)
+		original := []map[string]any{waitSent(t, sent), waitSent(t, sent)}
+		pushMessage(conn, request)
+		replayed := []map[string]any{waitSent(t, sent), waitSent(t, sent)}
+
+		assert.Equal(t, original, replayed)
+		assert.Equal(t, TypeResponse, replayed[0]["type"])
+		assert.Equal(t, TypeError, replayed[1]["type"])
+	})
+
+	t.Run("replays internal and deadline_exceeded errors", func(t *testing.T) {
+		for _, code := range []string{CodeInternal, CodeDeadlineExceeded} {
+			router, executions := countingRouter(func(res Responder) { _ = res.Fail(ErrorResponse{Code: code}) })
+			conn := NewMockConnection()
+			sent := captureSent(conn)
+			_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+
+			pushShoot(conn, SystemAPI, "channel-1", "req-1")
+			first := waitSent(t, sent)
+			pushShoot(conn, SystemAPI, "channel-1", "req-1")
+			second := waitSent(t, sent)
+			cancel()
+
+			assert.Equal(t, int32(1), executions.Load(), code)
+			assert.Equal(t, first, second, code)
+		}
+	})
+
+	t.Run("executes again requests refused as busy", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Fail(ErrorResponse{Code: CodeBusy}) })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		assert.Equal(t, TypeError, waitSent(t, sent)["type"])
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		assert.Equal(t, TypeError, waitSent(t, sent)["type"])
+
+		assert.Equal(t, int32(2), executions.Load())
+	})
+
+	t.Run("suppresses duplicate still being handled", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) {})
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+
+		assertNothingSent(t, sent)
+		assert.Equal(t, int32(1), executions.Load())
+	})
+
+	t.Run("keys on source, channel and request id", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Reply(nil) })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		pushShoot(conn, SystemAPI, "channel-2", "req-1")
+		pushShoot(conn, SystemDevice, "channel-1", "req-1")
+		for range 3 {
+			waitSent(t, sent)
+		}
+
+		assert.Equal(t, int32(3), executions.Load())
+	})
+
+	t.Run("forgets requests after ttl", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Reply(nil) })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{TTL: 20 * time.Millisecond}})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		waitSent(t, sent)
+		time.Sleep(40 * time.Millisecond)
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		waitSent(t, sent)
+
+		assert.Equal(t, int32(2), executions.Load())
+	})
+
+	t.Run("forgets oldest requests beyond max entries", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Reply(nil) })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{MaxEntries: 2}})
+		defer cancel()
+
+		for _, id := range []RequestID{"req-1", "req-2", "req-3", "req-3", "req-1"} {
+			pushShoot(conn, SystemAPI, "channel-1", id)
+			waitSent(t, sent)
+		}
+
+		// req-3 is replayed, req-1 was evicted and runs again
+		assert.Equal(t, int32(4), executions.Load())
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		router, executions := countingRouter(func(res Responder) { _ = res.Reply(nil) })
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		pushShoot(conn, SystemAPI, "channel-1", "req-1")
+		waitSent(t, sent)
+		waitSent(t, sent)
+
+		assert.Equal(t, int32(2), executions.Load())
+	})
+}
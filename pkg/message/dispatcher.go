@@ -0,0 +1,320 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultDispatcherQueueSize is the capacity of each dispatcher shard when
+// DispatcherConfig.QueueSize is not set
+const DefaultDispatcherQueueSize = 256
+
+// ErrDispatcherClosed is returned when submitting to a closed dispatcher
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+// DispatcherConfig configures a Dispatcher
+type DispatcherConfig struct {
+	// Workers is the number of shards, each served by its own worker,
+	// runtime.NumCPU() if zero
+	Workers int
+
+	// QueueSize is the capacity of each shard, DefaultDispatcherQueueSize if zero
+	QueueSize int
+
+	// Block waits for room in a full shard instead of dropping the message
+	Block bool
+
+	// OnDrop is called with every message discarded because its shard was
+	// full or the dispatcher was closed
+	OnDrop func(msg GenericMessage)
+}
+
+// DispatcherStats is a snapshot of the dispatcher queues
+type DispatcherStats struct {
+	// QueueDepth is the number of messages waiting in each shard
+	QueueDepth []int
+
+	// PeakQueueDepth is the highest depth observed in each shard
+	PeakQueueDepth []int
+
+	// Dispatched counts the messages handed to a worker
+	Dispatched uint64
+
+	// Dropped counts the messages discarded
+	Dropped uint64
+}
+
+// Dispatcher runs a handler on a pool of workers. Messages are sharded by
+// ChannelID so that the messages of a channel are handled one at a time and
+// in order, while different channels are handled in parallel. Messages
+// without a channel all share one shard.
+type Dispatcher struct {
+	handle func(ctx context.Context, msg GenericMessage)
+	block  bool
+	onDrop func(msg GenericMessage)
+	shards []*shard
+
+	// settle is called with the context of every message once it is
+	// handled or dropped
+	settle func(ctx context.Context)
+
+	// Close waits for in-flight submissions before draining the shards.
+	submitMutex sync.RWMutex
+	closeOnce   sync.Once
+	done        chan struct{}
+	workers     sync.WaitGroup
+
+	dispatched atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+// shard is the queue of one worker
+type shard struct {
+	queue chan dispatchItem
+	peak  atomic.Int64
+}
+
+// observe records the queue depth if it is the highest seen
+func (s *shard) observe(depth int) {
+	for {
+		peak := s.peak.Load()
+		if int64(depth) <= peak || s.peak.CompareAndSwap(peak, int64(depth)) {
+			return
+		}
+	}
+}
+
+type dispatchItem struct {
+	ctx context.Context
+	msg GenericMessage
+}
+
+// NewDispatcher creates a dispatcher and starts its workers
+func NewDispatcher(config DispatcherConfig, handle func(ctx context.Context, msg GenericMessage)) *Dispatcher {
+	return newSettlingDispatcher(config, handle, func(context.Context) {})
+}
+
+// newSettlingDispatcher creates a dispatcher that calls settle once each
+// message is handled or dropped
+func newSettlingDispatcher(config DispatcherConfig, handle func(ctx context.Context, msg GenericMessage), settle func(ctx context.Context)) *Dispatcher {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultDispatcherQueueSize
+	}
+
+	d := &Dispatcher{
+		handle: handle,
+		block:  config.Block,
+		onDrop: config.OnDrop,
+		settle: settle,
+		shards: make([]*shard, workers),
+		done:   make(chan struct{}),
+	}
+	for i := range d.shards {
+		d.shards[i] = &shard{queue: make(chan dispatchItem, queueSize)}
+	}
+	d.workers.Add(workers)
+	for _, s := range d.shards {
+		go d.work(s)
+	}
+	return d
+}
+
+// Submit queues a message on the shard of its channel. A full shard drops
+// the message, or waits for room when the dispatcher blocks.
+func (d *Dispatcher) Submit(ctx context.Context, msg GenericMessage) error {
+	d.submitMutex.RLock()
+	defer d.submitMutex.RUnlock()
+
+	select {
+	case <-d.done:
+		d.drop(ctx, msg)
+		return ErrDispatcherClosed
+	default:
+	}
+
+	s := d.shard(messageChannel(msg))
+	item := dispatchItem{ctx: ctx, msg: msg}
+	if d.block {
+		select {
+		case s.queue <- item:
+		case <-ctx.Done():
+			d.drop(ctx, msg)
+			return ctx.Err()
+		case <-d.done:
+			d.drop(ctx, msg)
+			return ErrDispatcherClosed
+		}
+	} else {
+		select {
+		case s.queue <- item:
+		default:
+			d.drop(ctx, msg)
+			return nil
+		}
+	}
+
+	s.observe(len(s.queue))
+	return nil
+}
+
+// Run submits every message received on msgs, typically Client.ReadMessage,
+// until the channel is closed or ctx is done
+func (d *Dispatcher) Run(ctx context.Context, msgs <-chan GenericMessage) {
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			if err := d.Submit(ctx, msg); errors.Is(err, ErrDispatcherClosed) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops the workers once they finish the message at hand and drops
+// the queued messages. It does not wait for the workers, use Wait for that.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() {
+		close(d.done)
+
+		d.submitMutex.Lock()
+		defer d.submitMutex.Unlock()
+
+		for _, s := range d.shards {
+		drain:
+			for {
+				select {
+				case item := <-s.queue:
+					d.drop(item.ctx, item.msg)
+				default:
+					break drain
+				}
+			}
+		}
+	})
+}
+
+// Wait blocks until the workers have stopped after Close
+func (d *Dispatcher) Wait() {
+	d.workers.Wait()
+}
+
+// Stats returns a snapshot of the dispatcher queues
+func (d *Dispatcher) Stats() DispatcherStats {
+	stats := DispatcherStats{
+		QueueDepth:     make([]int, len(d.shards)),
+		PeakQueueDepth: make([]int, len(d.shards)),
+		Dispatched:     d.dispatched.Load(),
+		Dropped:        d.dropped.Load(),
+	}
+	for i, s := range d.shards {
+		stats.QueueDepth[i] = len(s.queue)
+		stats.PeakQueueDepth[i] = int(s.peak.Load())
+	}
+	return stats
+}
+
+// work handles the messages of one shard in order
+func (d *Dispatcher) work(s *shard) {
+	defer d.workers.Done()
+	for {
+		select {
+		case item := <-s.queue:
+			d.dispatched.Add(1)
+			d.handle(item.ctx, item.msg)
+			d.settle(item.ctx)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// shard returns the shard serving a channel
+func (d *Dispatcher) shard(channelID ChannelID) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(channelID))
+	return d.shards[h.Sum32()%uint32(len(d.shards))]
+}
+
+func (d *Dispatcher) drop(ctx context.Context, msg GenericMessage) {
+	d.dropped.Add(1)
+	if d.onDrop != nil {
+		d.onDrop(msg)
+	}
+	d.settle(ctx)
+}
+
+// newDispatcher creates the dispatcher running the router handlers
+func (c *client) newDispatcher(config DispatcherConfig) *Dispatcher {
+	onDrop := config.OnDrop
+	config.OnDrop = func(msg GenericMessage) {
+		c.dropRouted(msg)
+		if onDrop != nil {
+			onDrop(msg)
+		}
+	}
+	return newSettlingDispatcher(config, c.handle, c.settleBatch)
+}
+
+// dropRouted answers a request discarded by the dispatcher with an
+// overloaded error, unless the client is closing
+func (c *client) dropRouted(msg GenericMessage) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	logger := c.logger.WithField("channel_id", messageChannel(msg))
+	req, ok := msg.(RequestMessage)
+	if !ok {
+		logger.Warn("Dispatcher queue full, dropping message")
+		return
+	}
+	logger.WithField("action", req.Action).Warn("Dispatcher queue full, rejecting request")
+	err := c.SendErrorToChannel(&req, ErrorResponse{
+		Code:    CodeOverloaded,
+		Message: fmt.Sprintf("too many queued messages for channel '%s'", req.ChannelID),
+	})
+	if err != nil {
+		c.logger.WithError(err).Error("Failed to send overloaded error")
+	}
+}
+
+// messageChannel returns the channel a message belongs to
+func messageChannel(msg GenericMessage) ChannelID {
+	switch m := msg.(type) {
+	case RequestMessage:
+		return m.ChannelID
+	case ResponseMessage:
+		return m.ChannelID
+	case ErrorMessage:
+		return m.ChannelID
+	case EventMessage:
+		return m.ChannelID
+	case ProgressMessage:
+		return m.ChannelID
+	case CancelMessage:
+		return m.ChannelID
+	case ChannelOpenMessage:
+		return m.ChannelID
+	case ChannelCloseMessage:
+		return m.ChannelID
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,328 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handledLog records the events handled per channel
+type handledLog struct {
+	mutex   sync.Mutex
+	handled map[ChannelID][]string
+}
+
+func (l *handledLog) handle(ctx context.Context, msg GenericMessage) {
+	event := msg.(EventMessage)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.handled == nil {
+		l.handled = make(map[ChannelID][]string)
+	}
+	l.handled[event.ChannelID] = append(l.handled[event.ChannelID], event.Action)
+}
+
+func (l *handledLog) count() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	n := 0
+	for _, actions := range l.handled {
+		n += len(actions)
+	}
+	return n
+}
+
+// signal notifies ch without blocking when it is already notified
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func sumDepth(depths []int) int {
+	total := 0
+	for _, depth := range depths {
+		total += depth
+	}
+	return total
+}
+
+func TestDispatcher_OrderPerChannel(t *testing.T) {
+	log := &handledLog{}
+	d := NewDispatcher(DispatcherConfig{Workers: 4, Block: true}, log.handle)
+	defer d.Close()
+
+	channels := []ChannelID{"channel-1", "channel-2", "channel-3", "channel-4", ""}
+	var want []string
+	for i := 0; i < 100; i++ {
+		want = append(want, fmt.Sprintf("e%d", i))
+	}
+	for _, action := range want {
+		for _, channelID := range channels {
+			require.NoError(t, d.Submit(context.Background(), EventMessage{Action: action, ChannelID: channelID}))
+		}
+	}
+
+	require.Eventually(t, func() bool { return log.count() == len(want)*len(channels) }, time.Second, 5*time.Millisecond)
+	for _, channelID := range channels {
+		assert.Equal(t, want, log.handled[channelID], "channel %q", channelID)
+	}
+	assert.Equal(t, uint64(len(want)*len(channels)), d.Stats().Dispatched)
+}
+
+func TestDispatcher_ChannelsRunInParallel(t *testing.T) {
+	release := make(chan struct{})
+	handled := make(chan ChannelID, 1)
+	d := NewDispatcher(DispatcherConfig{Workers: 8}, func(ctx context.Context, msg GenericMessage) {
+		event := msg.(EventMessage)
+		if event.Action == "slow" {
+			<-release
+		}
+		handled <- event.ChannelID
+	})
+	defer d.Close()
+	defer close(release)
+
+	// Find a channel served by another shard than the slow one.
+	fast := ChannelID("channel-2")
+	for i := 0; d.shard(fast) == d.shard("channel-1"); i++ {
+		fast = fmt.Sprintf("channel-%d", i)
+	}
+
+	require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "slow", ChannelID: "channel-1"}))
+	require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "fast", ChannelID: fast}))
+
+	select {
+	case channelID := <-handled:
+		assert.Equal(t, fast, channelID)
+	case <-time.After(time.Second):
+		t.Fatal("slow channel stalled the other channels")
+	}
+}
+
+func TestDispatcher_BoundedQueue(t *testing.T) {
+	t.Run("drops when full", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		recorder := &dropRecorder{}
+		d := NewDispatcher(DispatcherConfig{Workers: 1, QueueSize: 2, OnDrop: recorder.record}, func(ctx context.Context, msg GenericMessage) {
+			signal(started)
+			<-release
+		})
+		defer d.Close()
+
+		require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e1"}))
+		<-started
+		for _, action := range []string{"e2", "e3", "e4", "e5"} {
+			require.NoError(t, d.Submit(context.Background(), EventMessage{Action: action}))
+		}
+
+		assert.Equal(t, []string{"e4", "e5"}, recorder.actions(t, 2))
+		stats := d.Stats()
+		assert.Equal(t, []int{2}, stats.QueueDepth)
+		assert.Equal(t, []int{2}, stats.PeakQueueDepth)
+		assert.Equal(t, uint64(2), stats.Dropped)
+
+		close(release)
+		require.Eventually(t, func() bool { return d.Stats().QueueDepth[0] == 0 }, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []int{2}, d.Stats().PeakQueueDepth)
+	})
+
+	t.Run("block waits for room", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		d := NewDispatcher(DispatcherConfig{Workers: 1, QueueSize: 1, Block: true}, func(ctx context.Context, msg GenericMessage) {
+			signal(started)
+			<-release
+		})
+		defer d.Close()
+
+		require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e1"}))
+		<-started
+		require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e2"}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, d.Submit(ctx, EventMessage{Action: "e3"}), context.DeadlineExceeded)
+
+		close(release)
+		assert.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e4"}))
+	})
+
+	t.Run("close drops queued messages", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		recorder := &dropRecorder{}
+		d := NewDispatcher(DispatcherConfig{Workers: 1, OnDrop: recorder.record}, func(ctx context.Context, msg GenericMessage) {
+			signal(started)
+			<-release
+		})
+
+		require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e1"}))
+		<-started
+		require.NoError(t, d.Submit(context.Background(), EventMessage{Action: "e2"}))
+
+		d.Close()
+		close(release)
+		d.Wait()
+
+		assert.ErrorIs(t, d.Submit(context.Background(), EventMessage{Action: "e3"}), ErrDispatcherClosed)
+		assert.Equal(t, []string{"e2", "e3"}, recorder.actions(t, 2))
+	})
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	log := &handledLog{}
+	d := NewDispatcher(DispatcherConfig{Workers: 2}, log.handle)
+	defer d.Close()
+
+	msgs := make(chan GenericMessage, 3)
+	msgs <- EventMessage{Action: "e1", ChannelID: "channel-1"}
+	msgs <- EventMessage{Action: "e2", ChannelID: "channel-1"}
+	msgs <- EventMessage{Action: "e3", ChannelID: "channel-2"}
+	close(msgs)
+
+	d.Run(context.Background(), msgs)
+
+	require.Eventually(t, func() bool { return log.count() == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"e1", "e2"}, log.handled["channel-1"])
+	assert.Equal(t, []string{"e3"}, log.handled["channel-2"])
+}
+
+func TestClient_Dispatcher(t *testing.T) {
+	t.Run("slow handler does not stall other channels", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {
+			if req.ChannelID == "channel-1" {
+				<-release
+			}
+			_ = res.Reply(req.ChannelID)
+		})
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemDevice,
+			Router:     router,
+			Dispatcher: &DispatcherConfig{Workers: 64},
+		})
+		defer cancel()
+
+		impl := device.(*client)
+		fast := ChannelID("channel-2")
+		for i := 0; impl.dispatcher.shard(fast) == impl.dispatcher.shard("channel-1"); i++ {
+			fast = fmt.Sprintf("channel-%d", i)
+		}
+
+		pushRequest(conn, "req-1", "channel-1")
+		pushRequest(conn, "req-2", fast)
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, "req-2", envelope["reply_to"])
+		assert.Equal(t, fast, envelope["payload"])
+	})
+
+	t.Run("rejects requests when the queue is full", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {
+			signal(started)
+			<-release
+			_ = res.Reply(nil)
+		})
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemDevice,
+			Router:     router,
+			Dispatcher: &DispatcherConfig{Workers: 1, QueueSize: 1},
+		})
+		defer cancel()
+
+		pushRequest(conn, "req-1", "channel-1")
+		<-started
+		pushRequest(conn, "req-2", "channel-1")
+		pushRequest(conn, "req-3", "channel-1")
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "req-3", envelope["reply_to"])
+		assert.Equal(t, CodeOverloaded, envelope["error"].(map[string]any)["code"])
+
+		stats := device.Stats().Dispatcher
+		assert.Equal(t, 1, sumDepth(stats.QueueDepth))
+		assert.Equal(t, uint64(1), stats.Dropped)
+
+		close(release)
+		for _, want := range []string{"req-1", "req-2"} {
+			assert.Equal(t, want, waitSent(t, sent)["reply_to"])
+		}
+	})
+
+	t.Run("close runs drop callbacks without the client lock", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		started := make(chan struct{}, 1)
+		router := NewRouter()
+		router.Handle("camera.record", func(ctx context.Context, req *RequestMessage, res Responder) {
+			signal(started)
+			<-release
+		})
+
+		var device Client
+		recorder := &dropRecorder{}
+		conn := NewMockConnection()
+		captureSent(conn)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source: SystemDevice,
+			Router: router,
+			Dispatcher: &DispatcherConfig{Workers: 1, OnDrop: func(msg GenericMessage) {
+				assert.True(t, device.IsClosed())
+				recorder.record(msg)
+			}},
+		})
+		defer cancel()
+
+		pushRequest(conn, "req-1", "channel-1")
+		<-started
+		pushRequest(conn, "req-2", "channel-1")
+		pushRequest(conn, "req-3", "channel-1")
+		require.Eventually(t, func() bool {
+			return sumDepth(device.Stats().Dispatcher.QueueDepth) == 2
+		}, time.Second, 5*time.Millisecond)
+
+		closed := make(chan error, 1)
+		go func() { closed <- device.Close() }()
+		select {
+		case err := <-closed:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("Close did not return")
+		}
+		assert.Equal(t, []string{"camera.record", "camera.record"}, recorder.actions(t, 2))
+	})
+
+	t.Run("forwards unmatched messages", func(t *testing.T) {
+		conn := NewMockConnection()
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:     SystemAPI,
+			Router:     NewRouter(),
+			Dispatcher: &DispatcherConfig{Workers: 2},
+		})
+		defer cancel()
+
+		pushEvents(conn, "e1", "e2", "e3")
+		assert.Equal(t, []string{"e1", "e2", "e3"}, readN(t, device, 3))
+	})
+}
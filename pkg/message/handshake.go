@@ -0,0 +1,339 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the message protocol implemented by
+// this SDK. Peers that do not answer the handshake are reported as version 0.
+const ProtocolVersion = 1
+
+// DefaultHandshakeTimeout is how long the initiator waits for a welcome
+const DefaultHandshakeTimeout = 5 * time.Second
+
+// modulePath is used to find the SDK version in the build info
+const modulePath = "github.com/pavliha/aircast-sdk"
+
+// SDKVersion is the version of this SDK as recorded in the build info
+var SDKVersion = sdkVersion()
+
+func sdkVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// HandshakeConfig configures the hello/welcome handshake. A client always
+// answers a hello; only one side of a connection should initiate.
+type HandshakeConfig struct {
+	// Initiate sends a hello as soon as Listen starts. Devices typically initiate.
+	Initiate bool
+
+	// Codecs are the supported codecs in order of preference. When empty,
+	// only the codec the client was created with is offered.
+	Codecs []Codec
+
+	// Features are the feature flags advertised to the peer
+	Features []string
+
+	// RequiredFeatures refuses peers that do not support all of them
+	RequiredFeatures []string
+
+	// MinProtocolVersion refuses peers speaking an older protocol. Zero
+	// accepts legacy peers that never answer the hello.
+	MinProtocolVersion int
+
+	// Timeout is how long to wait for the welcome, DefaultHandshakeTimeout if zero
+	Timeout time.Duration
+}
+
+// ReconnectNotifier is implemented by connections that redial a dropped
+// link, e.g. the reconnect decorator. The peer behind a new link starts a
+// fresh session, so the client restarts the handshake from the codec it was
+// created with whenever fn is called.
+type ReconnectNotifier interface {
+	NotifyReconnect(fn func())
+}
+
+// Negotiation is the outcome of the handshake
+type Negotiation struct {
+	ProtocolVersion int
+	PeerSDKVersion  string
+	PeerSource      MessageSource
+	Codec           Codec
+	Features        []string
+}
+
+// HasFeature reports whether both sides support the feature
+func (n *Negotiation) HasFeature(feature string) bool {
+	return slices.Contains(n.Features, feature)
+}
+
+// handshake tracks the negotiation state of a client
+type handshake struct {
+	config HandshakeConfig
+
+	mutex  sync.Mutex
+	done   chan struct{}
+	result *Negotiation
+	err    error
+}
+
+func newHandshake(config *HandshakeConfig) *handshake {
+	h := &handshake{done: make(chan struct{})}
+	if config != nil {
+		h.config = *config
+	}
+	if h.config.Timeout <= 0 {
+		h.config.Timeout = DefaultHandshakeTimeout
+	}
+	return h
+}
+
+// settle records the outcome once; later outcomes are ignored
+func (h *handshake) settle(result *Negotiation, err error) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	select {
+	case <-h.done:
+		return false
+	default:
+	}
+	h.result, h.err = result, err
+	close(h.done)
+	return true
+}
+
+// settled returns a channel closed once the current handshake completes
+func (h *handshake) settled() <-chan struct{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.done
+}
+
+// reset forgets the outcome of a completed handshake so that a new one can
+// take place
+func (h *handshake) reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	select {
+	case <-h.done:
+		h.done = make(chan struct{})
+		h.result, h.err = nil, nil
+	default:
+	}
+}
+
+// check verifies that a peer is acceptable
+func (h *handshake) check(version int, features []string) error {
+	if version < h.config.MinProtocolVersion {
+		return fmt.Errorf("%w: protocol version %d is older than %d", ErrIncompatiblePeer, version, h.config.MinProtocolVersion)
+	}
+	for _, feature := range h.config.RequiredFeatures {
+		if !slices.Contains(features, feature) {
+			return fmt.Errorf("%w: missing required feature '%s'", ErrIncompatiblePeer, feature)
+		}
+	}
+	return nil
+}
+
+// codecs returns the names of the codecs offered to the peer
+func (h *handshake) codecs(current Codec) []string {
+	if len(h.config.Codecs) == 0 {
+		return []string{current.Name()}
+	}
+	return codecNames(h.config.Codecs)
+}
+
+func codecNames(codecs []Codec) []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
+
+// intersect returns the items of a that are also in b, in the order of a
+func intersect(a, b []string) []string {
+	var out []string
+	for _, item := range a {
+		if slices.Contains(b, item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Negotiation returns the outcome of the handshake, or nil while it is
+// still in progress or if it failed
+func (c *client) Negotiation() *Negotiation {
+	c.handshake.mutex.Lock()
+	defer c.handshake.mutex.Unlock()
+	return c.handshake.result
+}
+
+// AwaitNegotiation blocks until the handshake completes
+func (c *client) AwaitNegotiation(ctx context.Context) (*Negotiation, error) {
+	settled := c.handshake.settled()
+	select {
+	case <-settled:
+	case <-c.done:
+		// A failed handshake closes the client, report why.
+		select {
+		case <-settled:
+		default:
+			return nil, ErrClientClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.handshake.mutex.Lock()
+	defer c.handshake.mutex.Unlock()
+	return c.handshake.result, c.handshake.err
+}
+
+// sendHello starts the handshake and waits for the welcome in the background
+func (c *client) sendHello() {
+	hello := HelloMessage{
+		ProtocolVersion: ProtocolVersion,
+		SDKVersion:      SDKVersion,
+		Source:          c.source,
+		Codecs:          c.handshake.codecs(c.currentCodec()),
+		Features:        c.handshake.config.Features,
+	}
+	if err := c.Send(hello, nil); err != nil {
+		c.failHandshake(fmt.Errorf("failed to send hello: %w", err))
+		return
+	}
+
+	settled := c.handshake.settled()
+	go func() {
+		timer := time.NewTimer(c.handshake.config.Timeout)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-settled:
+			return
+		case <-c.done:
+			return
+		}
+
+		// The peer does not speak the handshake, treat it as legacy.
+		if err := c.handshake.check(0, nil); err != nil {
+			c.failHandshake(fmt.Errorf("%w: %w", ErrHandshakeTimeout, err))
+			return
+		}
+		c.logger.Warn("Peer did not answer hello, assuming legacy protocol")
+		c.handshake.settle(&Negotiation{Codec: c.currentCodec()}, nil)
+	}()
+}
+
+// handleHello answers a hello with the negotiated settings and adopts them
+func (c *client) handleHello(hello HelloMessage) {
+	welcome := WelcomeMessage{
+		ProtocolVersion: min(ProtocolVersion, hello.ProtocolVersion),
+		SDKVersion:      SDKVersion,
+		Source:          c.source,
+	}
+
+	if err := c.handshake.check(welcome.ProtocolVersion, hello.Features); err != nil {
+		welcome.Error = &ErrorResponse{Code: CodeIncompatible, Message: err.Error()}
+		_ = c.Send(welcome, nil)
+		c.failHandshake(err)
+		return
+	}
+
+	// Pick the first codec offered by the initiator that we support,
+	// downgrading to JSON when there is none.
+	codec := JSONCodec
+	offered := c.handshake.codecs(c.currentCodec())
+	for _, name := range hello.Codecs {
+		if found, ok := LookupCodec(name); ok && slices.Contains(offered, name) {
+			codec = found
+			break
+		}
+	}
+	welcome.Codec = codec.Name()
+	welcome.Features = intersect(hello.Features, c.handshake.config.Features)
+
+	// The welcome still uses the previous codec, the peer switches on receipt.
+	if err := c.Send(welcome, nil); err != nil {
+		c.logger.WithError(err).Error("Failed to send welcome")
+		return
+	}
+	c.setCodec(codec)
+	c.handshake.settle(&Negotiation{
+		ProtocolVersion: welcome.ProtocolVersion,
+		PeerSDKVersion:  hello.SDKVersion,
+		PeerSource:      hello.Source,
+		Codec:           codec,
+		Features:        welcome.Features,
+	}, nil)
+}
+
+// handleWelcome adopts the settings chosen by the peer
+func (c *client) handleWelcome(welcome WelcomeMessage) {
+	if welcome.Error != nil {
+		c.failHandshake(fmt.Errorf("%w: %w", ErrIncompatiblePeer, welcome.Error))
+		return
+	}
+	if err := c.handshake.check(welcome.ProtocolVersion, welcome.Features); err != nil {
+		c.failHandshake(err)
+		return
+	}
+
+	// JSON is accepted even when not offered, it is the common fallback.
+	codec, ok := LookupCodec(welcome.Codec)
+	if !ok || (welcome.Codec != CodecJSON && !slices.Contains(c.handshake.codecs(c.currentCodec()), welcome.Codec)) {
+		c.failHandshake(fmt.Errorf("%w: unsupported codec '%s'", ErrIncompatiblePeer, welcome.Codec))
+		return
+	}
+
+	c.setCodec(codec)
+	c.handshake.settle(&Negotiation{
+		ProtocolVersion: welcome.ProtocolVersion,
+		PeerSDKVersion:  welcome.SDKVersion,
+		PeerSource:      welcome.Source,
+		Codec:           codec,
+		Features:        welcome.Features,
+	}, nil)
+}
+
+// restartHandshake starts over with the peer behind a redialed link: the
+// client goes back to the codec it was created with and, as initiator,
+// sends a new hello
+func (c *client) restartHandshake() {
+	c.logger.Debug("Connection redialed, restarting handshake")
+	c.handshake.reset()
+	c.setCodec(c.baseCodec)
+	if c.handshake.config.Initiate {
+		c.sendHello()
+	}
+}
+
+// failHandshake records the failure and drops the connection
+func (c *client) failHandshake(err error) {
+	if c.handshake.settle(nil, err) {
+		c.logger.WithError(err).Error("Handshake failed, closing connection")
+	}
+	_ = c.Close()
+}
@@ -0,0 +1,193 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeConn is an in-memory connection connected to a peer
+type pipeConn struct {
+	in     chan []byte
+	peer   *pipeConn
+	mutex  sync.Mutex
+	closed bool
+}
+
+func newPipe() (*pipeConn, *pipeConn) {
+	a := &pipeConn{in: make(chan []byte, 1024)}
+	b := &pipeConn{in: make(chan []byte, 1024)}
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func (p *pipeConn) SendMessage(data []byte) error {
+	p.peer.mutex.Lock()
+	defer p.peer.mutex.Unlock()
+	if p.peer.closed {
+		return errors.New("pipe closed")
+	}
+	p.peer.in <- append([]byte(nil), data...)
+	return nil
+}
+
+func (p *pipeConn) ReadMessage() <-chan []byte {
+	return p.in
+}
+
+func (p *pipeConn) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.in)
+	}
+	return nil
+}
+
+func (p *pipeConn) IsClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
+
+// connectClients starts a device and an API client talking over a pipe
+func connectClients(t *testing.T, device, api ClientConfig) (Client, Client) {
+	t.Helper()
+	logger := logrus.NewEntry(logrus.New())
+	logger.Logger.SetLevel(logrus.FatalLevel)
+
+	deviceConn, apiConn := newPipe()
+	deviceClient := NewClient(logger, deviceConn, device)
+	apiClient := NewClient(logger, apiConn, api)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = deviceClient.Listen(ctx) }()
+	go func() { _ = apiClient.Listen(ctx) }()
+	return deviceClient, apiClient
+}
+
+func awaitNegotiation(t *testing.T, c Client) (*Negotiation, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.AwaitNegotiation(ctx)
+}
+
+func TestHandshake(t *testing.T) {
+	t.Run("negotiates version, codec and features", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("echo", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(req.Payload)
+		})
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router, Handshake: &HandshakeConfig{
+				Initiate: true,
+				Codecs:   []Codec{MsgpackCodec, JSONCodec},
+				Features: []string{"streaming", "batch"},
+			}},
+			ClientConfig{Source: SystemAPI, Handshake: &HandshakeConfig{
+				Codecs:   []Codec{CBORCodec, MsgpackCodec, JSONCodec},
+				Features: []string{"batch", "progress"},
+			}},
+		)
+
+		for _, c := range []Client{device, api} {
+			negotiation, err := awaitNegotiation(t, c)
+			require.NoError(t, err)
+			assert.Equal(t, ProtocolVersion, negotiation.ProtocolVersion)
+			assert.Equal(t, CodecMsgpack, negotiation.Codec.Name())
+			assert.Equal(t, []string{"batch"}, negotiation.Features)
+			assert.True(t, negotiation.HasFeature("batch"))
+			assert.False(t, negotiation.HasFeature("streaming"))
+			assert.Same(t, negotiation, c.Negotiation())
+		}
+		assert.Equal(t, SystemAPI, device.Negotiation().PeerSource)
+		assert.Equal(t, SystemDevice, api.Negotiation().PeerSource)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := api.Call(ctx, "echo", map[string]any{"armed": true}, "")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"armed": true}, resp.Payload)
+	})
+
+	t.Run("falls back to json", func(t *testing.T) {
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Handshake: &HandshakeConfig{Initiate: true, Codecs: []Codec{CBORCodec}}},
+			ClientConfig{Source: SystemAPI, Handshake: &HandshakeConfig{Codecs: []Codec{MsgpackCodec}}},
+		)
+
+		for _, c := range []Client{device, api} {
+			negotiation, err := awaitNegotiation(t, c)
+			require.NoError(t, err)
+			assert.Equal(t, CodecJSON, negotiation.Codec.Name())
+		}
+	})
+
+	t.Run("responder answers without configuration", func(t *testing.T) {
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Handshake: &HandshakeConfig{Initiate: true}},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		negotiation, err := awaitNegotiation(t, device)
+		require.NoError(t, err)
+		assert.Equal(t, ProtocolVersion, negotiation.ProtocolVersion)
+		assert.Equal(t, SDKVersion, negotiation.PeerSDKVersion)
+
+		_, err = awaitNegotiation(t, api)
+		require.NoError(t, err)
+	})
+
+	t.Run("missing required feature refuses peer", func(t *testing.T) {
+		device, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Handshake: &HandshakeConfig{Initiate: true, Features: []string{"batch"}}},
+			ClientConfig{Source: SystemAPI, Handshake: &HandshakeConfig{RequiredFeatures: []string{"streaming"}}},
+		)
+
+		for _, c := range []Client{device, api} {
+			_, err := awaitNegotiation(t, c)
+			assert.ErrorIs(t, err, ErrIncompatiblePeer)
+			assert.Nil(t, c.Negotiation())
+		}
+		assert.ErrorIs(t, device.Send(EventMessage{Action: "telemetry"}, nil), ErrClientClosed)
+	})
+
+	t.Run("legacy peer is accepted after timeout", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:    SystemDevice,
+			Handshake: &HandshakeConfig{Initiate: true, Timeout: 20 * time.Millisecond},
+		})
+		defer cancel()
+
+		negotiation, err := awaitNegotiation(t, device)
+		require.NoError(t, err)
+		assert.Equal(t, 0, negotiation.ProtocolVersion)
+		assert.Equal(t, CodecJSON, negotiation.Codec.Name())
+	})
+
+	t.Run("legacy peer is refused with minimum version", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		device, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:    SystemDevice,
+			Handshake: &HandshakeConfig{Initiate: true, Timeout: 20 * time.Millisecond, MinProtocolVersion: 1},
+		})
+		defer cancel()
+
+		_, err := awaitNegotiation(t, device)
+		assert.ErrorIs(t, err, ErrHandshakeTimeout)
+		assert.ErrorIs(t, err, ErrIncompatiblePeer)
+	})
+}
@@ -60,6 +60,39 @@ func Print(msg GenericMessage, config *PrintConfig) {
 		source = m.Source
 		channelID = m.ChannelID
 		payload = m.Error
+	case ProgressMessage:
+		msgType = "PROGRESS"
+		action = m.Action
+		source = m.Source
+		channelID = m.ChannelID
+		payload = m
+	case CancelMessage:
+		msgType = "CANCEL"
+		source = m.Source
+		channelID = m.ChannelID
+		payload = m
+	case ChannelOpenMessage:
+		msgType = "CHANNEL_OPEN"
+		source = m.Source
+		channelID = m.ChannelID
+		payload = m.Metadata
+	case ChannelCloseMessage:
+		msgType = "CHANNEL_CLOSE"
+		source = m.Source
+		channelID = m.ChannelID
+		payload = m.Reason
+	case BatchMessage:
+		msgType = "BATCH"
+		action = fmt.Sprintf("%d messages", len(m.Messages))
+		source = m.Source
+	case HelloMessage:
+		msgType = "HELLO"
+		source = m.Source
+		payload = m
+	case WelcomeMessage:
+		msgType = "WELCOME"
+		source = m.Source
+		payload = m
 	default:
 		msgType = "UNKNOWN"
 		fmt.Printf("%s%sUNKNOWN MESSAGE TYPE - DUMPING FULL CONTENT:%s\n", Bold, Red, Reset)
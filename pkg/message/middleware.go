@@ -0,0 +1,39 @@
+package message
+
+import (
+	"context"
+	"errors"
+)
+
+// MessageHandler processes a single message flowing through the client
+type MessageHandler func(ctx context.Context, msg GenericMessage) error
+
+// Middleware wraps a MessageHandler. A middleware may inspect the message,
+// pass a modified copy to next, drop it by returning nil without calling
+// next, or short-circuit by returning an error. An inbound request rejected
+// with an error is answered with an ErrorMessage built by ToErrorResponse.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Chain composes middlewares into one, the first being the outermost
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// ToErrorResponse converts an error into the ErrorResponse sent to the peer.
+// An *ErrorResponse found in the chain is used as is, any other error is
+// reported with the internal code.
+func ToErrorResponse(err error) ErrorResponse {
+	var errResp *ErrorResponse
+	if errors.As(err, &errResp) {
+		return *errResp
+	}
+	return ErrorResponse{
+		Code:    CodeInternal,
+		Message: err.Error(),
+	}
+}
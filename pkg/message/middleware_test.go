@@ -0,0 +1,161 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				calls = append(calls, name)
+				return next(ctx, msg)
+			}
+		}
+	}
+
+	handler := Chain(trace("first"), trace("second"))(func(ctx context.Context, msg GenericMessage) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	require.NoError(t, handler(context.Background(), EventMessage{}))
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestToErrorResponse(t *testing.T) {
+	t.Run("keeps error response", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", &ErrorResponse{Code: "unauthorized", Message: "bad token"})
+		assert.Equal(t, ErrorResponse{Code: "unauthorized", Message: "bad token"}, ToErrorResponse(err))
+	})
+
+	t.Run("maps other errors to internal", func(t *testing.T) {
+		assert.Equal(t, ErrorResponse{Code: CodeInternal, Message: "boom"}, ToErrorResponse(errors.New("boom")))
+	})
+}
+
+func TestClient_InboundMiddleware(t *testing.T) {
+	t.Run("short-circuits request with error message", func(t *testing.T) {
+		auth := func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				if req, ok := msg.(RequestMessage); ok && req.Source != SystemAPI {
+					return &ErrorResponse{Code: "unauthorized", Message: "unknown source"}
+				}
+				return next(ctx, msg)
+			}
+		}
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:  SystemDevice,
+			Inbound: []Middleware{auth},
+		})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "camera.zoom",
+			"source":     "intruder",
+			"request_id": "req-1",
+		})
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "unauthorized", envelope["error"].(map[string]any)["code"])
+
+		select {
+		case msg := <-client.ReadMessage():
+			t.Fatalf("rejected request should not be forwarded, got %T", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("drops and mutates messages", func(t *testing.T) {
+		filter := func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				event, ok := msg.(EventMessage)
+				if !ok || event.Action == "noise" {
+					return nil
+				}
+				event.ChannelID = "rewritten"
+				return next(ctx, event)
+			}
+		}
+
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:  SystemAPI,
+			Inbound: []Middleware{filter},
+		})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "noise"})
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "signal"})
+
+		select {
+		case msg := <-client.ReadMessage():
+			event, ok := msg.(EventMessage)
+			require.True(t, ok)
+			assert.Equal(t, "signal", event.Action)
+			assert.Equal(t, "rewritten", event.ChannelID)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	})
+}
+
+func TestClient_OutboundMiddleware(t *testing.T) {
+	t.Run("mutates outgoing messages", func(t *testing.T) {
+		stamp := func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				if event, ok := msg.(EventMessage); ok {
+					event.Payload = map[string]any{"stamped": true}
+					return next(ctx, event)
+				}
+				return next(ctx, msg)
+			}
+		}
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{
+			Source:   SystemDevice,
+			Outbound: []Middleware{stamp},
+		})
+
+		require.NoError(t, client.SendEventToChannel("telemetry", nil, "channel-1"))
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, map[string]any{"stamped": true}, envelope["payload"])
+		assert.Equal(t, "channel-1", envelope["channel_id"])
+	})
+
+	t.Run("short-circuits with error", func(t *testing.T) {
+		deny := func(next MessageHandler) MessageHandler {
+			return func(ctx context.Context, msg GenericMessage) error {
+				return errors.New("denied")
+			}
+		}
+
+		conn := NewMockConnection()
+		client := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{
+			Source:   SystemDevice,
+			Outbound: []Middleware{deny},
+		})
+
+		err := client.SendEventToChannel("telemetry", nil, "channel-1")
+		assert.EqualError(t, err, "denied")
+		conn.AssertNotCalled(t, "SendMessage")
+	})
+}
@@ -2,7 +2,6 @@ package message
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
@@ -15,7 +14,34 @@ var messageMapPool = sync.Pool{
 	},
 }
 
+// UnmarshalMessage parses a JSON encoded message into its typed struct
 func UnmarshalMessage(data []byte) (any, error) {
+	return UnmarshalMessageWithCodec(data, JSONCodec)
+}
+
+// UnmarshalMessageWithCodec parses a message encoded with codec into its
+// typed struct
+func UnmarshalMessageWithCodec(data []byte, codec Codec) (any, error) {
+	return UnmarshalMessageWithOptions(data, ParseOptions{Codec: codec})
+}
+
+// ParseOptions controls how UnmarshalMessageWithOptions parses a message
+type ParseOptions struct {
+	// Codec decodes the message, JSONCodec if nil
+	Codec Codec
+
+	// Strict rejects messages without a timestamp, except the handshake and
+	// batch envelopes
+	Strict bool
+}
+
+// UnmarshalMessageWithOptions parses a message into its typed struct
+func UnmarshalMessageWithOptions(data []byte, opts ParseOptions) (any, error) {
+	codec := opts.Codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
 	// Get generic message map from pool
 	genericMsg := messageMapPool.Get().(map[string]any)
 	defer func() {
@@ -26,11 +52,7 @@ func UnmarshalMessage(data []byte) (any, error) {
 		messageMapPool.Put(genericMsg)
 	}()
 
-	// Use decoder with bytes reader for better performance
-	reader := bytes.NewReader(data)
-	decoder := json.NewDecoder(reader)
-
-	if err := decoder.Decode(&genericMsg); err != nil {
+	if err := codec.Decode(data, &genericMsg); err != nil {
 		return nil, fmt.Errorf("failed to parse generic message: %w", err)
 	}
 
@@ -40,12 +62,64 @@ func UnmarshalMessage(data []byte) (any, error) {
 		return nil, errors.New("invalid message type field")
 	}
 
+	// Strict mode requires the send time on every message but the handshake
+	// and batches, whose messages are checked one by one.
+	if opts.Strict && genericMsg["timestamp"] == nil {
+		switch messageType {
+		case TypeHello, TypeWelcome, TypeBatch:
+		default:
+			return nil, ErrMissingTimestamp
+		}
+	}
+
+	// Handshake, cancel, channel and batch messages carry no action
+	switch messageType {
+	case TypeHello:
+		var hello HelloMessage
+		if err := codec.Decode(data, &hello); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal to HelloMessage: %w", err)
+		}
+		return hello, nil
+	case TypeWelcome:
+		var welcome WelcomeMessage
+		if err := codec.Decode(data, &welcome); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal to WelcomeMessage: %w", err)
+		}
+		return welcome, nil
+	case TypeCancel:
+		if genericMsg["request_id"] == nil && genericMsg["channel_id"] == nil {
+			return nil, errors.New("cancel must include 'request_id' or 'channel_id' field")
+		}
+		var cancel CancelMessage
+		if err := codec.Decode(data, &cancel); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal to CancelMessage: %w", err)
+		}
+		return cancel, nil
+	case TypeChannelOpen, TypeChannelClose:
+		if genericMsg["channel_id"] == nil {
+			return nil, fmt.Errorf("%s must include 'channel_id' field", messageType)
+		}
+		if messageType == TypeChannelOpen {
+			var open ChannelOpenMessage
+			if err := codec.Decode(data, &open); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal to ChannelOpenMessage: %w", err)
+			}
+			return open, nil
+		}
+		var closeMsg ChannelCloseMessage
+		if err := codec.Decode(data, &closeMsg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal to ChannelCloseMessage: %w", err)
+		}
+		return closeMsg, nil
+	case TypeBatch:
+		return unmarshalBatch(data, codec, opts)
+	}
+
 	// Quick validation of required fields only
 	if genericMsg["action"] == nil {
 		return nil, ErrMissingAction
 	}
 
-	// Reset reader and use json.Unmarshal for final parsing (faster than second decoder)
 	// Based on the type field, unmarshal into the appropriate struct.
 	switch messageType {
 	case TypeRequest:
@@ -53,7 +127,7 @@ func UnmarshalMessage(data []byte) (any, error) {
 			return nil, ErrMissingRequestID
 		}
 		var req RequestMessage
-		if err := json.Unmarshal(data, &req); err != nil {
+		if err := codec.Decode(data, &req); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal to RequestMessage: %w", err)
 		}
 		return req, nil
@@ -62,7 +136,7 @@ func UnmarshalMessage(data []byte) (any, error) {
 			return nil, errors.New("response must include 'reply_to' field")
 		}
 		var res ResponseMessage
-		if err := json.Unmarshal(data, &res); err != nil {
+		if err := codec.Decode(data, &res); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal to ResponseMessage: %w", err)
 		}
 		return res, nil
@@ -74,21 +148,68 @@ func UnmarshalMessage(data []byte) (any, error) {
 			return nil, errors.New("error must include 'error' field")
 		}
 		var errMsg ErrorMessage
-		if err := json.Unmarshal(data, &errMsg); err != nil {
+		if err := codec.Decode(data, &errMsg); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal to ErrorMessage: %w", err)
 		}
 		return errMsg, nil
 	case TypeEvent:
 		var event EventMessage
-		if err := json.Unmarshal(data, &event); err != nil {
+		if err := codec.Decode(data, &event); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal to EventMessage: %w", err)
 		}
 		return event, nil
+	case TypeProgress:
+		if genericMsg["reply_to"] == nil {
+			return nil, errors.New("progress must include 'reply_to' field")
+		}
+		var progress ProgressMessage
+		if err := codec.Decode(data, &progress); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal to ProgressMessage: %w", err)
+		}
+		return progress, nil
 	default:
 		return nil, fmt.Errorf("unknown message type: %s", messageType)
 	}
 }
 
+// unmarshalBatch splits a batch frame into its messages. Each message is
+// re-encoded with codec and parsed on its own.
+func unmarshalBatch(data []byte, codec Codec, opts ParseOptions) (any, error) {
+	var raw struct {
+		Source   MessageSource    `json:"source"`
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := codec.Decode(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal to BatchMessage: %w", err)
+	}
+	if raw.Messages == nil {
+		return nil, errors.New("batch must include 'messages' field")
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	batch := BatchMessage{Source: raw.Source, Messages: make([]GenericMessage, 0, len(raw.Messages))}
+	for i, envelope := range raw.Messages {
+		if envelope["type"] == TypeBatch {
+			return nil, fmt.Errorf("batch message %d: batch cannot contain a batch", i)
+		}
+		buf.Reset()
+		if err := codec.Encode(buf, envelope); err != nil {
+			return nil, fmt.Errorf("batch message %d: %w", i, err)
+		}
+		msg, err := UnmarshalMessageWithOptions(buf.Bytes(), ParseOptions{Codec: codec, Strict: opts.Strict})
+		if err != nil {
+			return nil, fmt.Errorf("batch message %d: %w", i, err)
+		}
+		batch.Messages = append(batch.Messages, msg)
+	}
+	return batch, nil
+}
+
 // validateMessage validates a message against the protocol requirements
 func validateMessage(msg map[string]any) error {
 	// Check required fields
@@ -103,8 +224,26 @@ func validateMessage(msg map[string]any) error {
 
 	// Validate type is one of the allowed values from the protocol
 	switch msgType {
-	case TypeRequest, TypeResponse, TypeError, TypeEvent:
+	case TypeRequest, TypeResponse, TypeError, TypeEvent, TypeProgress:
 		// Valid type according to protocol.md
+	case TypeHello, TypeWelcome:
+		// Handshake messages carry no action
+		return nil
+	case TypeCancel:
+		if msg["request_id"] == nil && msg["channel_id"] == nil {
+			return errors.New("cancel must include 'request_id' or 'channel_id' field")
+		}
+		return nil
+	case TypeChannelOpen, TypeChannelClose:
+		if msg["channel_id"] == nil {
+			return fmt.Errorf("%s must include 'channel_id' field", msgType)
+		}
+		return nil
+	case TypeBatch:
+		if msg["messages"] == nil {
+			return errors.New("batch must include 'messages' field")
+		}
+		return nil
 	default:
 		return fmt.Errorf("%w: '%s' is not a valid message type according to protocol", ErrInvalidMessageType, msgType)
 	}
@@ -132,6 +271,10 @@ func validateMessage(msg map[string]any) error {
 		if msg["error"] == nil {
 			return errors.New("error must include 'error' field")
 		}
+	case TypeProgress:
+		if msg["reply_to"] == nil {
+			return errors.New("progress must include 'reply_to' field")
+		}
 	}
 
 	return nil
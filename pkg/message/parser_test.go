@@ -598,3 +598,46 @@ func TestUnmarshalMessageEdgeCases(t *testing.T) {
 		assert.Equal(t, "مرحبا", payload["arabic"])
 	})
 }
+
+func TestUnmarshalMessageMetadata(t *testing.T) {
+	t.Run("decodes envelope metadata", func(t *testing.T) {
+		data := `{
+			"type": "event",
+			"action": "telemetry",
+			"source": "device",
+			"message_id": "msg-1",
+			"timestamp": 1760000000000,
+			"headers": {"trace_id": "abc"}
+		}`
+
+		msg, err := UnmarshalMessage([]byte(data))
+		require.NoError(t, err)
+
+		event, ok := msg.(EventMessage)
+		require.True(t, ok)
+		assert.Equal(t, "msg-1", event.MessageID)
+		assert.Equal(t, int64(1760000000000), event.Timestamp)
+		assert.Equal(t, map[string]string{"trace_id": "abc"}, event.Headers)
+	})
+
+	t.Run("strict mode requires timestamp", func(t *testing.T) {
+		data := []byte(`{"type": "request", "action": "test", "source": "api", "request_id": "req-1"}`)
+
+		_, err := UnmarshalMessageWithOptions(data, ParseOptions{Strict: true})
+		assert.ErrorIs(t, err, ErrMissingTimestamp)
+
+		_, err = UnmarshalMessageWithOptions(data, ParseOptions{})
+		assert.NoError(t, err)
+
+		stamped := []byte(`{"type": "request", "action": "test", "source": "api", "request_id": "req-1", "timestamp": 1}`)
+		_, err = UnmarshalMessageWithOptions(stamped, ParseOptions{Strict: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("strict mode ignores handshake messages", func(t *testing.T) {
+		data := []byte(`{"type": "hello", "protocol_version": 1, "source": "device"}`)
+
+		_, err := UnmarshalMessageWithOptions(data, ParseOptions{Strict: true})
+		assert.NoError(t, err)
+	})
+}
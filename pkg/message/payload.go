@@ -0,0 +1,82 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// DecodePayload decodes the payload of a request, response or event into T.
+// Payloads already holding a T are returned as is, raw JSON is decoded
+// directly and anything else (typically the map produced by
+// UnmarshalMessage) is converted through its JSON representation.
+func DecodePayload[T any](msg GenericMessage) (T, error) {
+	var out T
+
+	var payload any
+	switch m := msg.(type) {
+	case RequestMessage:
+		payload = m.Payload
+	case *RequestMessage:
+		payload = m.Payload
+	case ResponseMessage:
+		payload = m.Payload
+	case *ResponseMessage:
+		payload = m.Payload
+	case EventMessage:
+		payload = m.Payload
+	case *EventMessage:
+		payload = m.Payload
+	default:
+		return out, fmt.Errorf("message type has no payload: %T", msg)
+	}
+
+	if payload == nil {
+		return out, nil
+	}
+	if typed, ok := payload.(T); ok {
+		return typed, nil
+	}
+
+	var data []byte
+	switch p := payload.(type) {
+	case json.RawMessage:
+		data = p
+	case []byte:
+		data = p
+	default:
+		var err error
+		if data, err = json.Marshal(p); err != nil {
+			return out, fmt.Errorf("failed to encode payload: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return out, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return out, nil
+}
+
+// HandleTyped registers a handler whose request payload is decoded into Req
+// and whose result is sent back as the response payload. A payload that
+// cannot be decoded is answered with an invalid_payload error and an error
+// returned by fn is answered with ToErrorResponse.
+func HandleTyped[Req, Resp any](r Registrar, action MessageAction, fn func(ctx context.Context, req Req) (Resp, error)) {
+	r.Handle(action, func(ctx context.Context, msg *RequestMessage, res Responder) {
+		req, err := DecodePayload[Req](msg)
+		if err != nil {
+			_ = res.Fail(ErrorResponse{
+				Code:    CodeInvalidPayload,
+				Message: err.Error(),
+			})
+			return
+		}
+
+		resp, err := fn(ctx, req)
+		if err != nil {
+			_ = res.Fail(ToErrorResponse(err))
+			return
+		}
+		_ = res.Reply(resp)
+	})
+}
@@ -0,0 +1,112 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type zoomRequest struct {
+	Level int `json:"level"`
+}
+
+type zoomResponse struct {
+	Applied int `json:"applied"`
+}
+
+func TestDecodePayload(t *testing.T) {
+	t.Run("decodes parsed map payload", func(t *testing.T) {
+		msg, err := UnmarshalMessage([]byte(`{"type":"request","action":"camera.zoom","request_id":"req-1","payload":{"level":4}}`))
+		require.NoError(t, err)
+
+		req, err := DecodePayload[zoomRequest](msg)
+		require.NoError(t, err)
+		assert.Equal(t, zoomRequest{Level: 4}, req)
+	})
+
+	t.Run("decodes raw json payload", func(t *testing.T) {
+		event := &EventMessage{Payload: json.RawMessage(`{"level":2}`)}
+
+		req, err := DecodePayload[zoomRequest](event)
+		require.NoError(t, err)
+		assert.Equal(t, zoomRequest{Level: 2}, req)
+	})
+
+	t.Run("returns typed payload as is", func(t *testing.T) {
+		resp := ResponseMessage{Payload: zoomResponse{Applied: 3}}
+
+		out, err := DecodePayload[zoomResponse](resp)
+		require.NoError(t, err)
+		assert.Equal(t, zoomResponse{Applied: 3}, out)
+	})
+
+	t.Run("returns zero value for empty payload", func(t *testing.T) {
+		out, err := DecodePayload[zoomRequest](RequestMessage{})
+		require.NoError(t, err)
+		assert.Equal(t, zoomRequest{}, out)
+	})
+
+	t.Run("fails on mismatched payload", func(t *testing.T) {
+		_, err := DecodePayload[zoomRequest](RequestMessage{Payload: map[string]any{"level": "high"}})
+		assert.ErrorContains(t, err, "failed to decode payload")
+	})
+
+	t.Run("fails on message without payload", func(t *testing.T) {
+		_, err := DecodePayload[zoomRequest](ErrorMessage{})
+		assert.ErrorContains(t, err, "has no payload")
+	})
+}
+
+func TestHandleTyped(t *testing.T) {
+	router := NewRouter()
+	HandleTyped(router, "camera.zoom", func(ctx context.Context, req zoomRequest) (zoomResponse, error) {
+		if req.Level > 10 {
+			return zoomResponse{}, &ErrorResponse{Code: "out_of_range", Message: "level too high"}
+		}
+		if req.Level < 0 {
+			return zoomResponse{}, errors.New("negative level")
+		}
+		return zoomResponse{Applied: req.Level}, nil
+	})
+
+	conn := NewMockConnection()
+	sent := captureSent(conn)
+	_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+	defer cancel()
+
+	request := func(payload any) map[string]any {
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "camera.zoom",
+			"request_id": "req-1",
+			"payload":    payload,
+		})
+		return waitSent(t, sent)
+	}
+
+	t.Run("encodes response", func(t *testing.T) {
+		envelope := request(map[string]any{"level": 5})
+		assert.Equal(t, TypeResponse, envelope["type"])
+		assert.Equal(t, map[string]any{"applied": float64(5)}, envelope["payload"])
+	})
+
+	t.Run("maps error response", func(t *testing.T) {
+		envelope := request(map[string]any{"level": 11})
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "out_of_range", envelope["error"].(map[string]any)["code"])
+	})
+
+	t.Run("maps plain error to internal", func(t *testing.T) {
+		envelope := request(map[string]any{"level": -1})
+		assert.Equal(t, CodeInternal, envelope["error"].(map[string]any)["code"])
+	})
+
+	t.Run("rejects invalid payload", func(t *testing.T) {
+		envelope := request(map[string]any{"level": "max"})
+		assert.Equal(t, CodeInvalidPayload, envelope["error"].(map[string]any)["code"])
+	})
+}
@@ -0,0 +1,48 @@
+package message
+
+// CallOption configures a single Call or CallStream
+type CallOption func(*callOptions)
+
+// callOptions holds the settings of a call
+type callOptions struct {
+	onProgress func(ProgressMessage)
+	retry      *RetryPolicy
+}
+
+func newCallOptions(opts []CallOption) callOptions {
+	var options callOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// WithProgress calls fn with every progress message the peer sends while
+// handling the request. It runs on the Listen goroutine and must not block.
+func WithProgress(fn func(ProgressMessage)) CallOption {
+	return func(o *callOptions) {
+		o.onProgress = fn
+	}
+}
+
+// deliverProgress hands a progress message to the call it reports on.
+// It reports whether the message was consumed.
+func (c *client) deliverProgress(msg GenericMessage) bool {
+	progress, ok := msg.(ProgressMessage)
+	if !ok {
+		return false
+	}
+
+	c.pendingMutex.Lock()
+	call, pending := c.pending[progress.ReplyTo]
+	_, late := c.abandoned[progress.ReplyTo]
+	c.pendingMutex.Unlock()
+
+	if pending {
+		if call.options.onProgress != nil {
+			call.options.onProgress(progress)
+		}
+		return true
+	}
+	return late
+}
@@ -0,0 +1,115 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// progressRecorder collects progress messages passed to WithProgress
+type progressRecorder struct {
+	mutex   sync.Mutex
+	reports []ProgressMessage
+}
+
+func (r *progressRecorder) record(progress ProgressMessage) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports = append(r.reports, progress)
+}
+
+func (r *progressRecorder) percents() []float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	percents := make([]float64, len(r.reports))
+	for i, report := range r.reports {
+		percents[i] = report.Percent
+	}
+	return percents
+}
+
+func flashRouter() *Router {
+	router := NewRouter()
+	router.Handle("firmware.flash", func(ctx context.Context, req *RequestMessage, res Responder) {
+		_ = res.Progress(25, "erasing")
+		_ = res.Progress(75, "writing")
+		_ = res.Reply("flashed")
+	})
+	return router
+}
+
+func TestClient_Progress(t *testing.T) {
+	t.Run("call reports progress before response", func(t *testing.T) {
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: flashRouter()}, ClientConfig{Source: SystemAPI})
+
+		recorder := &progressRecorder{}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := api.Call(ctx, "firmware.flash", nil, "channel-1", WithProgress(recorder.record))
+		require.NoError(t, err)
+		assert.Equal(t, "flashed", resp.Payload)
+
+		assert.Equal(t, []float64{25, 75}, recorder.percents())
+		assert.Equal(t, "erasing", recorder.reports[0].Status)
+		assert.Equal(t, "firmware.flash", recorder.reports[0].Action)
+		assert.Equal(t, SystemDevice, recorder.reports[0].Source)
+		assert.Equal(t, "channel-1", recorder.reports[0].ChannelID)
+	})
+
+	t.Run("stream reports progress", func(t *testing.T) {
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: flashRouter()}, ClientConfig{Source: SystemAPI})
+
+		recorder := &progressRecorder{}
+		stream, err := api.CallStream(context.Background(), "firmware.flash", nil, "", WithProgress(recorder.record))
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"flashed"}, collect(t, stream))
+		assert.Equal(t, []float64{25, 75}, recorder.percents())
+	})
+
+	t.Run("progress without callback is consumed", func(t *testing.T) {
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: flashRouter()}, ClientConfig{Source: SystemAPI})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := api.Call(ctx, "firmware.flash", nil, "")
+		require.NoError(t, err)
+
+		select {
+		case msg := <-api.ReadMessage():
+			t.Fatalf("progress should not be forwarded, got %T", msg)
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
+	t.Run("unmatched progress is forwarded", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeProgress, "action": "firmware.flash", "reply_to": "req-1", "percent": 10})
+
+		select {
+		case msg := <-client.ReadMessage():
+			assert.Equal(t, ProgressMessage{Action: "firmware.flash", ReplyTo: "req-1", Percent: 10}, msg)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for progress")
+		}
+	})
+}
+
+func TestUnmarshalProgressMessage(t *testing.T) {
+	msg, err := UnmarshalMessage([]byte(`{"type": "progress", "action": "calibrate", "source": "device", "reply_to": "req-1", "percent": 42.5, "status": "rotating"}`))
+	require.NoError(t, err)
+	assert.Equal(t, ProgressMessage{Action: "calibrate", Source: SystemDevice, ReplyTo: "req-1", Percent: 42.5, Status: "rotating"}, msg)
+
+	_, err = UnmarshalMessage([]byte(`{"type": "progress", "action": "calibrate", "percent": 10}`))
+	assert.ErrorContains(t, err, "'reply_to'")
+
+	assert.NoError(t, validateMessage(map[string]any{"type": TypeProgress, "action": "calibrate", "reply_to": "req-1"}))
+	assert.Error(t, validateMessage(map[string]any{"type": TypeProgress, "action": "calibrate"}))
+}
@@ -0,0 +1,42 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// route dispatches a message to the router, recovering from a panicking
+// handler so that Listen keeps running
+func (c *client) route(ctx context.Context, msg GenericMessage) (consumed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.recoverHandler(msg, r)
+			consumed = true
+		}
+	}()
+	return c.router.Dispatch(ctx, c, msg)
+}
+
+// recoverHandler logs a handler panic and answers the request that caused
+// it with an internal error
+func (c *client) recoverHandler(msg GenericMessage, recovered any) {
+	c.panics.Add(1)
+
+	logger := c.logger.WithField("panic", recovered).WithField("stack", string(debug.Stack()))
+	switch m := msg.(type) {
+	case RequestMessage:
+		logger.WithField("action", m.Action).WithField("request_id", m.RequestID).Error("Request handler panicked")
+		err := c.SendErrorToChannel(&m, ErrorResponse{
+			Code:    CodeInternal,
+			Message: fmt.Sprintf("internal error while handling action '%s'", m.Action),
+		})
+		if err != nil {
+			c.logger.WithError(err).Error("Failed to send error for panicked handler")
+		}
+	case EventMessage:
+		logger.WithField("action", m.Action).Error("Event handler panicked")
+	default:
+		logger.Error("Handler panicked")
+	}
+}
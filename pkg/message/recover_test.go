@@ -0,0 +1,94 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cameraSettings struct {
+	ISO int
+}
+
+func panickingRouter() *Router {
+	router := NewRouter()
+	router.Handle("camera.configure", func(ctx context.Context, req *RequestMessage, res Responder) {
+		var settings *cameraSettings
+		_ = res.Reply(settings.ISO)
+	})
+	router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+		_ = res.Reply("zoomed")
+	})
+	return router
+}
+
+func TestClient_HandlerPanic(t *testing.T) {
+	t.Run("replies internal error and keeps listening", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: panickingRouter()})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeRequest, "action": "camera.configure", "request_id": "req-1", "channel_id": "channel-1"})
+		pushMessage(conn, map[string]any{"type": TypeRequest, "action": "camera.zoom", "request_id": "req-2"})
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "channel-1", envelope["channel_id"])
+		assert.Equal(t, CodeInternal, envelope["error"].(map[string]any)["code"])
+
+		envelope = waitSent(t, sent)
+		assert.Equal(t, TypeResponse, envelope["type"])
+		assert.Equal(t, "req-2", envelope["reply_to"])
+
+		assert.Equal(t, uint64(1), client.Stats().HandlerPanics)
+		assert.False(t, client.IsClosed())
+	})
+
+	t.Run("caller receives internal error", func(t *testing.T) {
+		device, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: panickingRouter()}, ClientConfig{Source: SystemAPI})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := api.Call(ctx, "camera.configure", nil, "")
+		var errResponse *ErrorResponse
+		require.ErrorAs(t, err, &errResponse)
+		assert.Equal(t, CodeInternal, errResponse.Code)
+		assert.Equal(t, uint64(1), device.Stats().HandlerPanics)
+
+		resp, err := api.Call(ctx, "camera.zoom", nil, "")
+		require.NoError(t, err)
+		assert.Equal(t, "zoomed", resp.Payload)
+	})
+
+	t.Run("event handler panic is counted", func(t *testing.T) {
+		handled := make(chan string, 1)
+		router := NewRouter()
+		router.OnEvent("telemetry", func(ctx context.Context, event *EventMessage) {
+			if event.Payload == nil {
+				panic("missing telemetry")
+			}
+			handled <- event.Payload.(string)
+		})
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "telemetry"})
+		pushMessage(conn, map[string]any{"type": TypeEvent, "action": "telemetry", "payload": "ok"})
+
+		select {
+		case payload := <-handled:
+			assert.Equal(t, "ok", payload)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+		assert.Equal(t, uint64(1), client.Stats().HandlerPanics)
+		assertNothingSent(t, sent)
+	})
+}
@@ -0,0 +1,129 @@
+package message
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// RequestIDGenerator creates the RequestID of an outgoing request
+type RequestIDGenerator func() RequestID
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// UUIDv4 returns a random RFC 9562 version 4 UUID
+func UUIDv4() RequestID {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// uuidv7State keeps UUIDv7s generated in the same millisecond ordered
+var uuidv7State struct {
+	sync.Mutex
+	millis  int64
+	counter uint16
+}
+
+// UUIDv7 returns an RFC 9562 version 7 UUID. It starts with the Unix time in
+// milliseconds, and IDs generated by the process are strictly increasing.
+func UUIDv7() RequestID {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	uuidv7State.Lock()
+	millis := time.Now().UnixMilli()
+	if millis > uuidv7State.millis {
+		// Start the 12-bit counter low enough to leave room for increments.
+		uuidv7State.millis = millis
+		uuidv7State.counter = binary.BigEndian.Uint16(b[6:8]) & 0x07ff
+	} else {
+		uuidv7State.counter++
+		if uuidv7State.counter > 0x0fff {
+			uuidv7State.millis++
+			uuidv7State.counter = 0
+		}
+	}
+	millis, counter := uuidv7State.millis, uuidv7State.counter
+	uuidv7State.Unlock()
+
+	b[0] = byte(millis >> 40)
+	b[1] = byte(millis >> 32)
+	b[2] = byte(millis >> 24)
+	b[3] = byte(millis >> 16)
+	b[4] = byte(millis >> 8)
+	b[5] = byte(millis)
+	b[6] = 0x70 | byte(counter>>8)
+	b[7] = byte(counter)
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+func formatUUID(b [16]byte) RequestID {
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:])
+}
+
+// ulidState keeps ULIDs generated in the same millisecond ordered
+var ulidState struct {
+	sync.Mutex
+	millis  int64
+	entropy [10]byte
+}
+
+// ULID returns a Universally Unique Lexicographically Sortable Identifier:
+// 26 Crockford base32 characters starting with the Unix time in
+// milliseconds. IDs generated by the process are strictly increasing.
+func ULID() RequestID {
+	ulidState.Lock()
+	millis := time.Now().UnixMilli()
+	if millis > ulidState.millis {
+		ulidState.millis = millis
+		_, _ = rand.Read(ulidState.entropy[:])
+	} else if !increment(ulidState.entropy[:]) {
+		ulidState.millis++
+		_, _ = rand.Read(ulidState.entropy[:])
+	}
+	millis, entropy := ulidState.millis, ulidState.entropy
+	ulidState.Unlock()
+
+	var out [26]byte
+	for i := 9; i >= 0; i-- {
+		out[i] = crockford[millis&0x1f]
+		millis >>= 5
+	}
+	// 80 bits of entropy in 16 characters of 5 bits each
+	hi := uint64(binary.BigEndian.Uint16(entropy[0:2]))
+	lo := binary.BigEndian.Uint64(entropy[2:10])
+	for i := 25; i >= 10; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// increment adds one to a big-endian number, reporting false on overflow
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package message
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	uuidV4Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func generate(generator RequestIDGenerator, n int) []RequestID {
+	ids := make([]RequestID, n)
+	for i := range ids {
+		ids[i] = generator()
+	}
+	return ids
+}
+
+func TestRequestIDGenerators(t *testing.T) {
+	generators := []struct {
+		name     string
+		generate RequestIDGenerator
+		pattern  *regexp.Regexp
+		sortable bool
+	}{
+		{"uuidv4", UUIDv4, uuidV4Pattern, false},
+		{"uuidv7", UUIDv7, uuidV7Pattern, true},
+		{"ulid", ULID, ulidPattern, true},
+	}
+
+	for _, g := range generators {
+		t.Run(g.name, func(t *testing.T) {
+			ids := generate(g.generate, 10000)
+
+			for _, id := range ids {
+				require.Regexp(t, g.pattern, id)
+			}
+
+			unique := slices.Compact(slices.Sorted(slices.Values(ids)))
+			assert.Len(t, unique, len(ids))
+
+			if g.sortable {
+				assert.True(t, slices.IsSorted(ids), "ids are not generated in order")
+			}
+		})
+	}
+}
+
+func TestUUIDv7_Timestamp(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := UUIDv7()
+
+	millis, err := strconv.ParseInt(strings.ReplaceAll(id[:13], "-", ""), 16, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, before, millis, 1000)
+}
+
+func TestULID_Timestamp(t *testing.T) {
+	before := time.Now().UnixMilli()
+	id := ULID()
+
+	var millis int64
+	for _, c := range id[:10] {
+		millis = millis<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	assert.InDelta(t, before, millis, 1000)
+}
+
+func TestClient_RequestIDGenerator(t *testing.T) {
+	t.Run("calls use the configured generator", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source:             SystemAPI,
+			RequestIDGenerator: func() RequestID { return "fixed-id" },
+		})
+		defer cancel()
+
+		ctx, callCancel := context.WithCancel(context.Background())
+		defer callCancel()
+		go func() { _, _ = client.Call(ctx, "camera.zoom", nil, "") }()
+
+		assert.Equal(t, "fixed-id", waitSent(t, sent)["request_id"])
+	})
+
+	t.Run("send fills a missing request id", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		require.NoError(t, client.Send(RequestMessage{Action: "camera.zoom"}, nil))
+		assert.Regexp(t, uuidV7Pattern, waitSent(t, sent)["request_id"])
+
+		require.NoError(t, client.Send(RequestMessage{Action: "camera.zoom", RequestID: "mine"}, nil))
+		assert.Equal(t, "mine", waitSent(t, sent)["request_id"])
+	})
+}
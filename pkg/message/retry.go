@@ -0,0 +1,162 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Retry policy defaults
+const (
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff     = 5 * time.Second
+	DefaultRetryMultiplier     = 2.0
+	DefaultRetryJitter         = 0.2
+)
+
+// RetryPolicy decides whether and when Call sends a request again after a
+// failed attempt. Every attempt reuses the same RequestID so that a receiver
+// with deduplication enabled executes the request at most once.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the second attempt
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after every failed attempt
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to this fraction of its value
+	Jitter float64
+
+	// AttemptTimeout bounds how long one attempt waits for its reply. A
+	// reply to an earlier attempt still completes the call. Zero waits for
+	// the context.
+	AttemptTimeout time.Duration
+
+	// RetryableCodes are the ErrorResponse codes worth another attempt, such
+	// as "busy". The peer did not execute the request, so these are retried
+	// even for actions that are not idempotent.
+	RetryableCodes []string
+
+	// Idempotent allows retrying after send failures and attempt timeouts,
+	// where the peer may already have executed the request
+	Idempotent bool
+}
+
+// WithRetry sets the retry policy of a Call, overriding ClientConfig.Retry
+func WithRetry(policy RetryPolicy) CallOption {
+	return func(o *callOptions) {
+		o.retry = &policy
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryMultiplier
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = DefaultRetryJitter
+	}
+	return p
+}
+
+// backoff returns the delay after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(p.MaxBackoff))
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// retryable reports whether a failed attempt may be retried
+func (p RetryPolicy) retryable(err error) bool {
+	var errResponse *ErrorResponse
+	switch {
+	case errors.As(err, &errResponse):
+		return slices.Contains(p.RetryableCodes, errResponse.Code)
+	case errors.Is(err, ErrClientClosed),
+		errors.Is(err, ErrChannelClosed),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	default:
+		// Send failures and attempt timeouts
+		return p.Idempotent
+	}
+}
+
+// callWithRetry sends a registered request until it succeeds, fails with an
+// error the policy does not retry, or runs out of attempts
+func (c *client) callWithRetry(ctx context.Context, req RequestMessage, call *pendingCall, policy RetryPolicy) (*ResponseMessage, error) {
+	policy = policy.withDefaults()
+	logger := c.logger.WithField("action", req.Action).WithField("request_id", req.RequestID)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, req, call, policy)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			c.giveUp(req, err)
+			return nil, err
+		}
+
+		logger.WithError(err).WithField("attempt", attempt).Debug("Call attempt failed, retrying")
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			c.giveUp(req, err)
+			return nil, ctx.Err()
+		}
+
+		// An error reply completed the pending call, register it again.
+		var errResponse *ErrorResponse
+		if errors.As(err, &errResponse) {
+			if call, err = c.registerCall(req, call.options); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// attempt sends the request once and waits for its reply
+func (c *client) attempt(ctx context.Context, req RequestMessage, call *pendingCall, policy RetryPolicy) (*ResponseMessage, error) {
+	req.TimeoutMs = requestTimeout(ctx)
+	if err := c.Send(req, &req.ChannelID); err != nil {
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if policy.AttemptTimeout > 0 {
+		timer := time.NewTimer(policy.AttemptTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	return c.awaitReply(ctx, req, call, timeout)
+}
+
+// giveUp releases the pending call of a request whose last attempt failed
+func (c *client) giveUp(req RequestMessage, err error) {
+	if errors.Is(err, ErrAttemptTimeout) {
+		c.abandonCall(req.RequestID)
+		c.sendCancel(req, err.Error())
+		return
+	}
+	c.removeCall(req.RequestID)
+}
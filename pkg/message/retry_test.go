@@ -0,0 +1,253 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// attemptLog records the requests sent by the client under test
+type attemptLog struct {
+	mutex sync.Mutex
+	ids   []string
+}
+
+func (l *attemptLog) add(id string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.ids = append(l.ids, id)
+	return len(l.ids)
+}
+
+func (l *attemptLog) requestIDs() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.ids...)
+}
+
+// answerAttempts makes the mock connection answer the nth request with
+// the envelope built by reply, or nothing when it returns nil
+func answerAttempts(conn *MockConnection, reply func(n int, req map[string]any) map[string]any) *attemptLog {
+	log := &attemptLog{}
+	conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+		var req map[string]any
+		_ = json.Unmarshal(args.Get(0).([]byte), &req)
+		if req["type"] != TypeRequest {
+			return
+		}
+		if envelope := reply(log.add(req["request_id"].(string)), req); envelope != nil {
+			pushMessage(conn, envelope)
+		}
+	}).Return(nil)
+	return log
+}
+
+func busy(req map[string]any) map[string]any {
+	return map[string]any{
+		"type":     TypeError,
+		"action":   req["action"],
+		"reply_to": req["request_id"],
+		"error":    map[string]any{"code": "busy", "message": "camera is busy"},
+	}
+}
+
+func succeed(req map[string]any) map[string]any {
+	return map[string]any{"type": TypeResponse, "action": req["action"], "reply_to": req["request_id"], "payload": "done"}
+}
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, RetryableCodes: []string{"busy"}}
+
+func TestClient_CallRetry(t *testing.T) {
+	t.Run("retries retryable codes with the same request id", func(t *testing.T) {
+		conn := NewMockConnection()
+		log := answerAttempts(conn, func(n int, req map[string]any) map[string]any {
+			if n < 3 {
+				return busy(req)
+			}
+			return succeed(req)
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		resp, err := client.Call(context.Background(), "camera.shoot", nil, "", WithRetry(fastRetry))
+		require.NoError(t, err)
+		assert.Equal(t, "done", resp.Payload)
+
+		ids := log.requestIDs()
+		require.Len(t, ids, 3)
+		assert.Equal(t, ids[0], ids[1])
+		assert.Equal(t, ids[0], ids[2])
+		assert.Equal(t, 0, pendingCount(client))
+	})
+
+	t.Run("retries are executed by a deduplicating receiver", func(t *testing.T) {
+		var executions atomic.Int32
+		router := NewRouter()
+		router.Handle("camera.shoot", func(ctx context.Context, req *RequestMessage, res Responder) {
+			if executions.Add(1) < 3 {
+				_ = res.Fail(ErrorResponse{Code: "busy"})
+				return
+			}
+			_ = res.Reply("done")
+		})
+		_, api := connectClients(t,
+			ClientConfig{Source: SystemDevice, Router: router, Dedup: &DedupConfig{}},
+			ClientConfig{Source: SystemAPI},
+		)
+
+		resp, err := api.Call(context.Background(), "camera.shoot", nil, "channel-1", WithRetry(fastRetry))
+		require.NoError(t, err)
+		assert.Equal(t, "done", resp.Payload)
+		assert.Equal(t, int32(3), executions.Load())
+	})
+
+	t.Run("returns last error when attempts run out", func(t *testing.T) {
+		conn := NewMockConnection()
+		log := answerAttempts(conn, func(n int, req map[string]any) map[string]any { return busy(req) })
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := client.Call(context.Background(), "camera.shoot", nil, "", WithRetry(fastRetry))
+		var errResponse *ErrorResponse
+		require.ErrorAs(t, err, &errResponse)
+		assert.Equal(t, "busy", errResponse.Code)
+		assert.Len(t, log.requestIDs(), 3)
+		assert.Equal(t, 0, pendingCount(client))
+	})
+
+	t.Run("does not retry other codes", func(t *testing.T) {
+		conn := NewMockConnection()
+		log := answerAttempts(conn, func(n int, req map[string]any) map[string]any {
+			envelope := busy(req)
+			envelope["error"] = map[string]any{"code": CodeNotFound}
+			return envelope
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := client.Call(context.Background(), "camera.shoot", nil, "", WithRetry(fastRetry))
+		assert.Error(t, err)
+		assert.Len(t, log.requestIDs(), 1)
+	})
+
+	t.Run("retries attempt timeouts of idempotent actions", func(t *testing.T) {
+		conn := NewMockConnection()
+		log := answerAttempts(conn, func(n int, req map[string]any) map[string]any {
+			if n == 1 {
+				return nil
+			}
+			return succeed(req)
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		policy := fastRetry
+		policy.AttemptTimeout = 20 * time.Millisecond
+		policy.Idempotent = true
+		resp, err := client.Call(context.Background(), "settings.get", nil, "", WithRetry(policy))
+		require.NoError(t, err)
+		assert.Equal(t, "done", resp.Payload)
+		assert.Len(t, log.requestIDs(), 2)
+	})
+
+	t.Run("does not retry attempt timeouts of unsafe actions", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := make(chan map[string]any, 10)
+		conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+			var envelope map[string]any
+			_ = json.Unmarshal(args.Get(0).([]byte), &envelope)
+			sent <- envelope
+		}).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		policy := fastRetry
+		policy.AttemptTimeout = 20 * time.Millisecond
+		_, err := client.Call(context.Background(), "camera.shoot", nil, "", WithRetry(policy))
+		assert.ErrorIs(t, err, ErrAttemptTimeout)
+
+		assert.Equal(t, TypeRequest, waitSent(t, sent)["type"])
+		assert.Equal(t, TypeCancel, waitSent(t, sent)["type"])
+		assert.Equal(t, 0, pendingCount(client))
+	})
+
+	t.Run("send failures", func(t *testing.T) {
+		for _, idempotent := range []bool{true, false} {
+			conn := NewMockConnection()
+			conn.On("SendMessage", mock.Anything).Return(errors.New("broken pipe"))
+			client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+
+			policy := fastRetry
+			policy.Idempotent = idempotent
+			_, err := client.Call(context.Background(), "settings.get", nil, "", WithRetry(policy))
+			assert.ErrorContains(t, err, "broken pipe")
+
+			attempts := 1
+			if idempotent {
+				attempts = 3
+			}
+			conn.AssertNumberOfCalls(t, "SendMessage", attempts)
+			assert.Equal(t, 0, pendingCount(client))
+			cancel()
+		}
+	})
+
+	t.Run("policy configured per action", func(t *testing.T) {
+		conn := NewMockConnection()
+		log := answerAttempts(conn, func(n int, req map[string]any) map[string]any {
+			if n == 1 {
+				return busy(req)
+			}
+			return succeed(req)
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{
+			Source: SystemAPI,
+			Retry:  map[MessageAction]RetryPolicy{"camera.shoot": fastRetry},
+		})
+		defer cancel()
+
+		_, err := client.Call(context.Background(), "camera.shoot", nil, "")
+		require.NoError(t, err)
+		assert.Len(t, log.requestIDs(), 2)
+
+		_, err = client.Call(context.Background(), "camera.zoom", nil, "")
+		require.NoError(t, err)
+		assert.Len(t, log.requestIDs(), 3)
+	})
+
+	t.Run("context cancels backoff", func(t *testing.T) {
+		conn := NewMockConnection()
+		answerAttempts(conn, func(n int, req map[string]any) map[string]any { return busy(req) })
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		policy := fastRetry
+		policy.InitialBackoff = time.Hour
+		ctx, callCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer callCancel()
+
+		start := time.Now()
+		_, err := client.Call(ctx, "camera.shoot", nil, "", WithRetry(policy))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, 0, pendingCount(client))
+	})
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}.withDefaults()
+
+	for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		delay := policy.backoff(attempt)
+		assert.GreaterOrEqual(t, delay, base/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, delay, base*3/2, "attempt %d", attempt)
+	}
+}
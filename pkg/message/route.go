@@ -0,0 +1,169 @@
+package message
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Action patterns are matched segment by segment, segments being separated
+// by dots. A "*" segment matches exactly one segment and a trailing "**"
+// matches one or more, so "camera.*" matches "camera.zoom" but not
+// "camera.zoom.in", while "camera.**" matches both.
+const (
+	wildcardSegment = "*"
+	wildcardRest    = "**"
+)
+
+// HandlerMiddleware wraps the handler a request is routed to
+type HandlerMiddleware func(next Handler) Handler
+
+// Route describes a pattern registered on a router
+type Route struct {
+	// Type is TypeRequest for handlers and TypeEvent for event handlers
+	Type MessageType
+
+	// Action is the exact action or wildcard pattern
+	Action MessageAction
+}
+
+// pattern is a parsed action pattern containing wildcards
+type pattern struct {
+	action   MessageAction
+	segments []string
+}
+
+// isPattern reports whether the action contains wildcards
+func isPattern(action MessageAction) bool {
+	return strings.Contains(action, wildcardSegment)
+}
+
+func isWildcard(segment string) bool {
+	return segment == wildcardSegment || segment == wildcardRest
+}
+
+// compilePattern validates a wildcard pattern
+func compilePattern(action MessageAction) (pattern, error) {
+	segments := strings.Split(action, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+			return pattern{}, fmt.Errorf("empty segment in action pattern %q", action)
+		case segment == wildcardRest && i != len(segments)-1:
+			return pattern{}, fmt.Errorf("%q must be the last segment of action pattern %q", wildcardRest, action)
+		case !isWildcard(segment) && strings.Contains(segment, wildcardSegment):
+			return pattern{}, fmt.Errorf("partial wildcard in action pattern %q", action)
+		}
+	}
+	return pattern{action: action, segments: segments}, nil
+}
+
+// parsePattern is compilePattern for registrations, which panic on
+// malformed patterns as they are programming errors
+func parsePattern(action MessageAction) pattern {
+	p, err := compilePattern(action)
+	if err != nil {
+		panic("message: " + err.Error())
+	}
+	return p
+}
+
+// match reports whether the action segments match the pattern
+func (p pattern) match(segments []string) bool {
+	for i, want := range p.segments {
+		if want == wildcardRest {
+			return len(segments) > i
+		}
+		if i >= len(segments) || (want != wildcardSegment && want != segments[i]) {
+			return false
+		}
+	}
+	return len(segments) == len(p.segments)
+}
+
+// segmentRank orders segments from the most to the least specific
+func segmentRank(segment string) int {
+	switch segment {
+	case wildcardSegment:
+		return 1
+	case wildcardRest:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// comparePatterns orders patterns by precedence. Segments are compared from
+// left to right and the first that differs decides: a literal beats "*"
+// which beats "**". Otherwise the longer pattern wins.
+func comparePatterns(a, b pattern) int {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if c := cmp.Compare(segmentRank(a.segments[i]), segmentRank(b.segments[i])); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(len(b.segments), len(a.segments)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.action, b.action)
+}
+
+// insertPattern adds a pattern keeping the slice in order of precedence
+func insertPattern(patterns []pattern, p pattern) []pattern {
+	i, _ := slices.BinarySearchFunc(patterns, p, comparePatterns)
+	return slices.Insert(patterns, i, p)
+}
+
+// scopedMiddleware is a middleware applied to the actions matching a pattern
+type scopedMiddleware struct {
+	pattern    pattern
+	middleware HandlerMiddleware
+}
+
+// Registrar registers request handlers, it is implemented by Router and Group
+type Registrar interface {
+	Handle(action MessageAction, h Handler)
+}
+
+// Group registers handlers and middleware under a common action prefix
+type Group struct {
+	router *Router
+	prefix MessageAction
+}
+
+// Group returns a group registering its handlers under "prefix."
+func (r *Router) Group(prefix MessageAction) *Group {
+	parsePattern(prefix)
+	return &Group{router: r, prefix: prefix}
+}
+
+// Group returns a nested group under "prefix."
+func (g *Group) Group(prefix MessageAction) *Group {
+	return g.router.Group(g.action(prefix))
+}
+
+// Prefix returns the action prefix of the group
+func (g *Group) Prefix() MessageAction {
+	return g.prefix
+}
+
+// Handle registers the handler for requests with the action "prefix.action"
+func (g *Group) Handle(action MessageAction, h Handler) {
+	g.router.Handle(g.action(action), h)
+}
+
+// OnEvent registers a handler for events with the action "prefix.action"
+func (g *Group) OnEvent(action MessageAction, fn EventHandler) {
+	g.router.OnEvent(g.action(action), fn)
+}
+
+// Use adds middleware wrapping every request handler of the group,
+// including those of nested groups and those registered before Use
+func (g *Group) Use(middlewares ...HandlerMiddleware) {
+	g.router.Use(g.action(wildcardRest), middlewares...)
+}
+
+func (g *Group) action(action MessageAction) MessageAction {
+	return g.prefix + "." + action
+}
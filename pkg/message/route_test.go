@@ -0,0 +1,167 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replyName returns a handler replying with its name
+func replyName(name string) Handler {
+	return func(ctx context.Context, req *RequestMessage, res Responder) {
+		_ = res.Reply(name)
+	}
+}
+
+// dispatch routes a request for the action and returns the reply envelope
+func dispatch(t *testing.T, router *Router, action MessageAction) map[string]any {
+	t.Helper()
+	conn := NewMockConnection()
+	sent := captureSent(conn)
+	device := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemDevice})
+
+	require.True(t, router.Dispatch(context.Background(), device, RequestMessage{Action: action, RequestID: "req-1"}))
+	return waitSent(t, sent)
+}
+
+func TestRouter_Wildcards(t *testing.T) {
+	router := NewRouter()
+	router.Handle("camera.zoom", replyName("exact"))
+	router.Handle("camera.*", replyName("camera.*"))
+	router.Handle("*.zoom", replyName("*.zoom"))
+	router.Handle("camera.**", replyName("camera.**"))
+	router.Handle("*.*.in", replyName("*.*.in"))
+
+	tests := []struct {
+		action string
+		want   string
+	}{
+		{"camera.zoom", "exact"},
+		{"camera.focus", "camera.*"},
+		{"gimbal.zoom", "*.zoom"},
+		{"camera.zoom.in", "camera.**"},
+		{"camera.zoom.out", "camera.**"},
+		{"gimbal.zoom.in", "*.*.in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			assert.Equal(t, tt.want, dispatch(t, router, tt.action)["payload"])
+		})
+	}
+
+	t.Run("single segment wildcard does not match deeper actions", func(t *testing.T) {
+		envelope := dispatch(t, router, "gimbal.tilt.up")
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, CodeNotFound, envelope["error"].(map[string]any)["code"])
+	})
+
+	t.Run("rest wildcard requires a segment", func(t *testing.T) {
+		envelope := dispatch(t, router, "camera")
+		assert.Equal(t, CodeNotFound, envelope["error"].(map[string]any)["code"])
+	})
+
+	t.Run("rejects malformed patterns", func(t *testing.T) {
+		h := replyName("")
+		assert.Panics(t, func() { router.Handle("camera.**.zoom", h) })
+		assert.Panics(t, func() { router.Handle("camera.zo*", h) })
+		assert.Panics(t, func() { router.Handle("camera..*", h) })
+		assert.Panics(t, func() { router.Handle("camera.*", h) })
+	})
+}
+
+func TestRouter_WildcardEvents(t *testing.T) {
+	router := NewRouter()
+	var mutex sync.Mutex
+	var received []string
+	record := func(name string) EventHandler {
+		return func(ctx context.Context, event *EventMessage) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, name)
+		}
+	}
+	router.OnEvent("stream.**", record("stream.**"))
+	router.OnEvent("stream.*", record("stream.*"))
+	router.OnEvent("stream.started", record("exact"))
+
+	assert.True(t, router.Dispatch(context.Background(), nil, EventMessage{Action: "stream.started"}))
+	assert.Equal(t, []string{"exact", "stream.*", "stream.**"}, received)
+
+	assert.False(t, router.Dispatch(context.Background(), nil, EventMessage{Action: "camera.started"}))
+}
+
+func TestRouter_Group(t *testing.T) {
+	router := NewRouter()
+	camera := router.Group("camera")
+	assert.Equal(t, "camera", camera.Prefix())
+
+	var calls []string
+	trace := func(name string) HandlerMiddleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *RequestMessage, res Responder) {
+				calls = append(calls, name+":"+req.Action)
+				next(ctx, req, res)
+			}
+		}
+	}
+
+	camera.Handle("zoom", replyName("zoom"))
+	camera.Use(trace("camera"))
+	camera.Group("lens").Handle("*", replyName("lens"))
+	router.Use("*.focus", trace("focus"))
+	router.Handle("camera.focus", replyName("focus"))
+	router.Handle("stream.start", replyName("stream"))
+
+	assert.Equal(t, "zoom", dispatch(t, router, "camera.zoom")["payload"])
+	assert.Equal(t, "lens", dispatch(t, router, "camera.lens.swap")["payload"])
+	assert.Equal(t, "focus", dispatch(t, router, "camera.focus")["payload"])
+	assert.Equal(t, "stream", dispatch(t, router, "stream.start")["payload"])
+
+	assert.Equal(t, []string{
+		"camera:camera.zoom",
+		"camera:camera.lens.swap",
+		"camera:camera.focus",
+		"focus:camera.focus",
+	}, calls)
+
+	t.Run("typed handlers", func(t *testing.T) {
+		HandleTyped(router.Group("gimbal"), "tilt", func(ctx context.Context, req map[string]int) (int, error) {
+			return req["angle"] * 2, nil
+		})
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		device := NewClient(logrus.NewEntry(logrus.New()), conn, ClientConfig{Source: SystemDevice})
+		router.Dispatch(context.Background(), device, RequestMessage{
+			Action:    "gimbal.tilt",
+			RequestID: "req-1",
+			Payload:   map[string]any{"angle": 15},
+		})
+		assert.Equal(t, float64(30), waitSent(t, sent)["payload"])
+	})
+}
+
+func TestRouter_Routes(t *testing.T) {
+	router := NewRouter()
+	assert.Empty(t, router.Routes())
+
+	h := replyName("")
+	router.Handle("stream.start", h)
+	router.Group("camera").Handle("*", h)
+	router.Handle("camera.zoom", h)
+	router.OnEvent("telemetry.**", func(ctx context.Context, event *EventMessage) {})
+	router.OnEvent("armed", func(ctx context.Context, event *EventMessage) {})
+	router.OnEvent("armed", func(ctx context.Context, event *EventMessage) {})
+
+	assert.Equal(t, []Route{
+		{Type: TypeRequest, Action: "camera.*"},
+		{Type: TypeRequest, Action: "camera.zoom"},
+		{Type: TypeRequest, Action: "stream.start"},
+		{Type: TypeEvent, Action: "armed"},
+		{Type: TypeEvent, Action: "telemetry.**"},
+	}, router.Routes())
+}
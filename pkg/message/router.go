@@ -0,0 +1,234 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Handler handles a request routed to its action
+type Handler func(ctx context.Context, req *RequestMessage, res Responder)
+
+// EventHandler handles an event routed to its action
+type EventHandler func(ctx context.Context, event *EventMessage)
+
+// Responder replies to the request currently being handled
+type Responder interface {
+	// Reply sends a response with the given payload
+	Reply(payload any) error
+
+	// Fail sends an error response
+	Fail(errResponse ErrorResponse) error
+
+	// Stream starts a streamed reply made of several partial responses
+	Stream() ResponseStream
+
+	// Progress reports how far the request has got, percent from 0 to 100
+	Progress(percent float64, status string) error
+}
+
+// responder implements the Responder interface on top of a Client
+type responder struct {
+	client Client
+	req    *RequestMessage
+}
+
+func (r *responder) Reply(payload any) error {
+	return r.client.SendResponse(r.req, payload)
+}
+
+func (r *responder) Fail(errResponse ErrorResponse) error {
+	return r.client.SendErrorToChannel(r.req, errResponse)
+}
+
+func (r *responder) Progress(percent float64, status string) error {
+	return r.client.SendMessageToChannel(r.req.ChannelID, ProgressMessage{
+		Action:    r.req.Action,
+		ChannelID: r.req.ChannelID,
+		ReplyTo:   r.req.RequestID,
+		Percent:   percent,
+		Status:    status,
+	})
+}
+
+func (r *responder) Stream() ResponseStream {
+	return &responseStream{client: r.client, req: r.req}
+}
+
+// Router dispatches incoming requests and events to the handlers registered
+// for their action. Attach it to a client with ClientConfig.Router and it is
+// driven by Client.Listen.
+//
+// Actions may be registered as wildcard patterns such as "camera.*". An exact
+// registration always takes precedence over a pattern, and patterns are tried
+// from the most to the least specific.
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[MessageAction]Handler
+	events   map[MessageAction][]EventHandler
+
+	// Wildcard patterns in order of precedence
+	handlerPatterns []pattern
+	eventPatterns   []pattern
+
+	middlewares []scopedMiddleware
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[MessageAction]Handler),
+		events:   make(map[MessageAction][]EventHandler),
+	}
+}
+
+// Handle registers the handler for requests with the given action or
+// pattern. It panics if a handler is already registered for it.
+func (r *Router) Handle(action MessageAction, h Handler) {
+	if h == nil {
+		panic("message: nil handler")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.handlers[action]; exists {
+		panic(fmt.Sprintf("message: multiple registrations for action %q", action))
+	}
+	if isPattern(action) {
+		r.handlerPatterns = insertPattern(r.handlerPatterns, parsePattern(action))
+	}
+	r.handlers[action] = h
+}
+
+// OnEvent registers a handler for events with the given action or pattern.
+// Several handlers may be registered for the same action.
+func (r *Router) OnEvent(action MessageAction, fn EventHandler) {
+	if fn == nil {
+		panic("message: nil event handler")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.events[action]; !exists && isPattern(action) {
+		r.eventPatterns = insertPattern(r.eventPatterns, parsePattern(action))
+	}
+	r.events[action] = append(r.events[action], fn)
+}
+
+// Use adds middleware wrapping the handlers of the requests whose action
+// matches the pattern. Middleware runs in the order it was added.
+func (r *Router) Use(action MessageAction, middlewares ...HandlerMiddleware) {
+	p := parsePattern(action)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, mw := range middlewares {
+		if mw == nil {
+			panic("message: nil middleware")
+		}
+		r.middlewares = append(r.middlewares, scopedMiddleware{pattern: p, middleware: mw})
+	}
+}
+
+// Routes lists the registered actions and patterns, request handlers first
+func (r *Router) Routes() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]Route, 0, len(r.handlers)+len(r.events))
+	for _, action := range slices.Sorted(maps.Keys(r.handlers)) {
+		routes = append(routes, Route{Type: TypeRequest, Action: action})
+	}
+	for _, action := range slices.Sorted(maps.Keys(r.events)) {
+		routes = append(routes, Route{Type: TypeEvent, Action: action})
+	}
+	return routes
+}
+
+// handler finds the handler for an action and wraps it with the middleware
+// matching the action
+func (r *Router) handler(action MessageAction) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[action]
+	if !ok && len(r.handlerPatterns) > 0 {
+		segments := strings.Split(action, ".")
+		for _, p := range r.handlerPatterns {
+			if p.match(segments) {
+				h, ok = r.handlers[p.action], true
+				break
+			}
+		}
+	}
+	if !ok || len(r.middlewares) == 0 {
+		return h, ok
+	}
+
+	segments := strings.Split(action, ".")
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		if r.middlewares[i].pattern.match(segments) {
+			h = r.middlewares[i].middleware(h)
+		}
+	}
+	return h, true
+}
+
+// eventHandlers returns the handlers for an event action, exact
+// registrations first
+func (r *Router) eventHandlers(action MessageAction) []EventHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handlers := r.events[action]
+	if len(r.eventPatterns) == 0 {
+		return handlers
+	}
+
+	segments := strings.Split(action, ".")
+	handlers = slices.Clip(handlers)
+	for _, p := range r.eventPatterns {
+		if p.action != action && p.match(segments) {
+			handlers = append(handlers, r.events[p.action]...)
+		}
+	}
+	return handlers
+}
+
+// Dispatch routes a message to its handlers and reports whether it was
+// consumed. Requests are always consumed: those without a handler are
+// answered with a not_found error. Events without a handler are not.
+func (r *Router) Dispatch(ctx context.Context, c Client, msg GenericMessage) bool {
+	switch m := msg.(type) {
+	case RequestMessage:
+		h, ok := r.handler(m.Action)
+
+		res := &responder{client: c, req: &m}
+		if !ok {
+			_ = res.Fail(ErrorResponse{
+				Code:    CodeNotFound,
+				Message: fmt.Sprintf("no handler for action '%s'", m.Action),
+			})
+			return true
+		}
+		h(ctx, &m, res)
+		return true
+	case EventMessage:
+		handlers := r.eventHandlers(m.Action)
+
+		if len(handlers) == 0 {
+			return false
+		}
+		for _, fn := range handlers {
+			fn(ctx, &m)
+		}
+		return true
+	default:
+		return false
+	}
+}
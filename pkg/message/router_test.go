@@ -0,0 +1,175 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// captureSent records every envelope the client writes to the mock connection
+func captureSent(conn *MockConnection) <-chan map[string]any {
+	sent := make(chan map[string]any, 100)
+	conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+		var envelope map[string]any
+		_ = json.Unmarshal(args.Get(0).([]byte), &envelope)
+		sent <- envelope
+	}).Return(nil)
+	return sent
+}
+
+func pushMessage(conn *MockConnection, msg map[string]any) {
+	data, _ := json.Marshal(msg)
+	conn.msgCh <- data
+}
+
+func waitSent(t *testing.T, sent <-chan map[string]any) map[string]any {
+	t.Helper()
+	select {
+	case envelope := <-sent:
+		return envelope
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for sent message")
+		return nil
+	}
+}
+
+func TestRouter_Handle(t *testing.T) {
+	t.Run("dispatches request to handler", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply(map[string]any{"zoom": req.Payload.(map[string]any)["level"]})
+		})
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "camera.zoom",
+			"source":     SystemAPI,
+			"request_id": "req-1",
+			"channel_id": "channel-1",
+			"payload":    map[string]any{"level": 3},
+		})
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeResponse, envelope["type"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "channel-1", envelope["channel_id"])
+		assert.Equal(t, SystemDevice, envelope["source"])
+		assert.Equal(t, map[string]any{"zoom": float64(3)}, envelope["payload"])
+	})
+
+	t.Run("responds with error through responder", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("camera.zoom", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Fail(ErrorResponse{Code: "busy", Message: "camera is busy"})
+		})
+
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "camera.zoom",
+			"request_id": "req-1",
+		})
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "busy", envelope["error"].(map[string]any)["code"])
+	})
+
+	t.Run("answers unmatched request with not_found", func(t *testing.T) {
+		conn := NewMockConnection()
+		sent := captureSent(conn)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice, Router: NewRouter()})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeRequest,
+			"action":     "unknown",
+			"request_id": "req-1",
+		})
+
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeError, envelope["type"])
+		assert.Equal(t, CodeNotFound, envelope["error"].(map[string]any)["code"])
+
+		select {
+		case msg := <-client.ReadMessage():
+			t.Fatalf("request should not be forwarded, got %T", msg)
+		default:
+		}
+	})
+
+	t.Run("panics on duplicate registration", func(t *testing.T) {
+		router := NewRouter()
+		h := func(ctx context.Context, req *RequestMessage, res Responder) {}
+		router.Handle("camera.zoom", h)
+		assert.Panics(t, func() { router.Handle("camera.zoom", h) })
+		assert.Panics(t, func() { router.Handle("camera.focus", nil) })
+	})
+}
+
+func TestRouter_OnEvent(t *testing.T) {
+	t.Run("dispatches event to all handlers", func(t *testing.T) {
+		router := NewRouter()
+		received := make(chan string, 2)
+		router.OnEvent("stream.started", func(ctx context.Context, event *EventMessage) {
+			received <- "first:" + event.ChannelID
+		})
+		router.OnEvent("stream.started", func(ctx context.Context, event *EventMessage) {
+			received <- "second:" + event.ChannelID
+		})
+
+		conn := NewMockConnection()
+		_, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Router: router})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":       TypeEvent,
+			"action":     "stream.started",
+			"channel_id": "channel-1",
+		})
+
+		for _, want := range []string{"first:channel-1", "second:channel-1"} {
+			select {
+			case got := <-received:
+				assert.Equal(t, want, got)
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for event handler")
+			}
+		}
+	})
+
+	t.Run("forwards unmatched events", func(t *testing.T) {
+		conn := NewMockConnection()
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Router: NewRouter()})
+		defer cancel()
+
+		pushMessage(conn, map[string]any{
+			"type":   TypeEvent,
+			"action": "stream.stopped",
+		})
+
+		select {
+		case msg := <-client.ReadMessage():
+			event, ok := msg.(EventMessage)
+			require.True(t, ok)
+			assert.Equal(t, "stream.stopped", event.Action)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	})
+}
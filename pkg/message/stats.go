@@ -0,0 +1,22 @@
+package message
+
+// ClientStats is a snapshot of the counters of a client
+type ClientStats struct {
+	// HandlerPanics counts the router and subscription handlers that panicked
+	HandlerPanics uint64
+
+	// Dispatcher holds the queue depths of the dispatcher, zero when the
+	// client has none
+	Dispatcher DispatcherStats
+}
+
+// Stats returns a snapshot of the client counters
+func (c *client) Stats() ClientStats {
+	stats := ClientStats{
+		HandlerPanics: c.panics.Load(),
+	}
+	if c.dispatcher != nil {
+		stats.Dispatcher = c.dispatcher.Stats()
+	}
+	return stats
+}
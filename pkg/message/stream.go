@@ -0,0 +1,257 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// streamBufferSize is how many partial responses are buffered per stream,
+// and how many out-of-order parts are held back for reordering
+const streamBufferSize = 64
+
+// errStreamOverflow ends a stream whose caller does not keep up with the peer
+var errStreamOverflow = fmt.Errorf("%w: too many undelivered parts", ErrStreamClosed)
+
+// ResponseStream sends the partial responses of a streamed reply. Parts are
+// numbered in the order they are sent; Close or Fail ends the stream.
+type ResponseStream interface {
+	// Send sends the next partial response
+	Send(payload any) error
+
+	// Close sends the end-of-stream marker
+	Close() error
+
+	// Fail ends the stream with an error response
+	Fail(errResponse ErrorResponse) error
+}
+
+// responseStream implements the ResponseStream interface on top of a Client
+type responseStream struct {
+	client Client
+	req    *RequestMessage
+
+	mutex    sync.Mutex
+	sequence uint64
+	ended    bool
+}
+
+func (s *responseStream) Send(payload any) error {
+	return s.send(payload, false)
+}
+
+func (s *responseStream) Close() error {
+	return s.send(nil, true)
+}
+
+func (s *responseStream) Fail(errResponse ErrorResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ended {
+		return ErrStreamClosed
+	}
+	s.ended = true
+	return s.client.SendErrorToChannel(s.req, errResponse)
+}
+
+func (s *responseStream) send(payload any, end bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ended {
+		return ErrStreamClosed
+	}
+	s.ended = end
+	s.sequence++
+	return s.client.SendMessageToChannel(s.req.ChannelID, ResponseMessage{
+		Action:    s.req.Action,
+		Payload:   payload,
+		ChannelID: s.req.ChannelID,
+		ReplyTo:   s.req.RequestID,
+		Sequence:  s.sequence,
+		End:       end,
+		Headers:   maps.Clone(s.req.Headers),
+	})
+}
+
+// Stream receives the partial responses of a call made with CallStream. A
+// stream that is not drained fails with ErrStreamClosed once its buffers
+// are full, and the request is cancelled on the peer.
+type Stream struct {
+	ch       chan *ResponseMessage
+	err      error
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Responses returns the partial responses in sequence order. The channel is
+// closed when the stream ends; check Err afterwards.
+func (s *Stream) Responses() <-chan *ResponseMessage {
+	return s.ch
+}
+
+// Err returns why the stream ended once Responses is closed: nil after the
+// end-of-stream marker, an *ErrorResponse when the peer failed the request,
+// or the context or client error.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Close stops receiving and asks the peer to cancel the request; later
+// partial responses are dropped
+func (s *Stream) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// CallStream sends a request whose reply is streamed and returns the stream
+// of partial responses. A plain response is delivered as a single part.
+func (c *client) CallStream(ctx context.Context, action MessageAction, payload any, channelID ChannelID, opts ...CallOption) (*Stream, error) {
+	req := RequestMessage{
+		Action:    action,
+		Payload:   payload,
+		Source:    c.source,
+		RequestID: c.newRequestID(),
+		ChannelID: channelID,
+		TimeoutMs: requestTimeout(ctx),
+	}
+
+	call, err := c.registerStream(req, newCallOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Send(req, &channelID); err != nil {
+		c.removeCall(req.RequestID)
+		return nil, err
+	}
+
+	stream := &Stream{
+		ch:   make(chan *ResponseMessage, streamBufferSize),
+		stop: make(chan struct{}),
+	}
+	go c.receiveStream(ctx, req, call, stream)
+	return stream, nil
+}
+
+// registerStream adds a pending streamed call for the given request
+func (c *client) registerStream(req RequestMessage, options callOptions) (*pendingCall, error) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if c.pending == nil {
+		return nil, ErrClientClosed
+	}
+	call := &pendingCall{
+		replyCh:   make(chan GenericMessage, streamBufferSize),
+		options:   options,
+		channelID: req.ChannelID,
+		failed:    make(chan struct{}),
+		stream:    true,
+		closed:    make(chan struct{}),
+		stopped:   make(chan struct{}),
+		overflow:  make(chan struct{}),
+	}
+	c.pending[req.RequestID] = call
+	return call, nil
+}
+
+// receiveStream reorders the replies of a streamed call by sequence number
+// and delivers them until the stream ends
+func (c *client) receiveStream(ctx context.Context, req RequestMessage, call *pendingCall, stream *Stream) {
+	defer close(stream.ch)
+	defer close(call.stopped)
+
+	id := req.RequestID
+	next := uint64(1)
+	early := make(map[uint64]ResponseMessage)
+	for {
+		select {
+		case reply := <-call.replyCh:
+			switch m := reply.(type) {
+			case ErrorMessage:
+				c.removeCall(id)
+				stream.err = &m.Error
+				return
+			case ResponseMessage:
+				if m.Sequence == 0 {
+					c.removeCall(id)
+					_ = stream.deliver(ctx, m, call.overflow)
+					return
+				}
+				// Parts already delivered are duplicates.
+				if m.Sequence < next {
+					continue
+				}
+				if _, ok := early[m.Sequence]; !ok && len(early) >= streamBufferSize {
+					c.failStream(req, stream, errStreamOverflow)
+					return
+				}
+				early[m.Sequence] = m
+			}
+
+			for {
+				part, ok := early[next]
+				if !ok {
+					break
+				}
+				delete(early, next)
+				next++
+
+				if part.End {
+					c.removeCall(id)
+					return
+				}
+				if err := stream.deliver(ctx, part, call.overflow); err != nil {
+					c.failStream(req, stream, err)
+					return
+				}
+			}
+		case <-call.closed:
+			stream.err = ErrClientClosed
+			return
+		case <-call.failed:
+			stream.err = call.err
+			return
+		case <-call.overflow:
+			c.failStream(req, stream, errStreamOverflow)
+			return
+		case <-stream.stop:
+			c.failStream(req, stream, ErrStreamClosed)
+			return
+		case <-ctx.Done():
+			c.failStream(req, stream, ctx.Err())
+			return
+		}
+	}
+}
+
+// failStream ends a stream with err and asks the peer to cancel the request
+func (c *client) failStream(req RequestMessage, stream *Stream, err error) {
+	c.abandonCall(req.RequestID)
+	c.sendCancel(req, err.Error())
+	stream.err = err
+}
+
+// deliver hands a partial response to the caller
+func (s *Stream) deliver(ctx context.Context, part ResponseMessage, overflow <-chan struct{}) error {
+	select {
+	case s.ch <- &part:
+		return nil
+	case <-overflow:
+		return errStreamOverflow
+	case <-s.stop:
+		return ErrStreamClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isFinalReply reports whether a reply ends its call
+func isFinalReply(msg GenericMessage) bool {
+	if m, ok := msg.(ResponseMessage); ok {
+		return m.Sequence == 0 || m.End
+	}
+	return true
+}
@@ -0,0 +1,299 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// replyStreamWith makes the mock connection answer every request it sends
+// with the envelopes built by reply
+func replyStreamWith(conn *MockConnection, reply func(req map[string]any) []map[string]any) {
+	conn.On("SendMessage", mock.Anything).Run(func(args mock.Arguments) {
+		var req map[string]any
+		_ = json.Unmarshal(args.Get(0).([]byte), &req)
+		if req["type"] != TypeRequest {
+			return
+		}
+		for _, envelope := range reply(req) {
+			pushMessage(conn, envelope)
+		}
+	}).Return(nil)
+}
+
+func part(req map[string]any, seq int, payload any, end bool) map[string]any {
+	return map[string]any{
+		"type":     TypeResponse,
+		"action":   req["action"],
+		"reply_to": req["request_id"],
+		"seq":      seq,
+		"payload":  payload,
+		"end":      end,
+	}
+}
+
+// collect reads a stream until it ends
+func collect(t *testing.T, stream *Stream) []any {
+	t.Helper()
+	var payloads []any
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case res, ok := <-stream.Responses():
+			if !ok {
+				return payloads
+			}
+			payloads = append(payloads, res.Payload)
+		case <-timeout:
+			t.Fatal("timeout waiting for stream to end")
+		}
+	}
+}
+
+func TestClient_CallStream(t *testing.T) {
+	t.Run("delivers parts until end marker", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("logs.tail", func(ctx context.Context, req *RequestMessage, res Responder) {
+			stream := res.Stream()
+			for _, line := range []string{"boot", "armed", "takeoff"} {
+				_ = stream.Send(line)
+			}
+			_ = stream.Close()
+		})
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		stream, err := api.CallStream(context.Background(), "logs.tail", nil, "channel-1")
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"boot", "armed", "takeoff"}, collect(t, stream))
+		assert.NoError(t, stream.Err())
+		assert.Equal(t, 0, pendingCount(api))
+	})
+
+	t.Run("error message ends stream", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("scan", func(ctx context.Context, req *RequestMessage, res Responder) {
+			stream := res.Stream()
+			_ = stream.Send("wifi-1")
+			_ = stream.Fail(ErrorResponse{Code: "radio_off", Message: "radio is off"})
+		})
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		stream, err := api.CallStream(context.Background(), "scan", nil, "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"wifi-1"}, collect(t, stream))
+		var errResponse *ErrorResponse
+		require.ErrorAs(t, stream.Err(), &errResponse)
+		assert.Equal(t, "radio_off", errResponse.Code)
+		assert.Equal(t, 0, pendingCount(api))
+	})
+
+	t.Run("reorders parts by sequence", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyStreamWith(conn, func(req map[string]any) []map[string]any {
+			return []map[string]any{
+				part(req, 2, "b", false),
+				part(req, 4, nil, true),
+				part(req, 1, "a", false),
+				part(req, 3, "c", false),
+			}
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "files.list", nil, "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"a", "b", "c"}, collect(t, stream))
+		assert.NoError(t, stream.Err())
+	})
+
+	t.Run("plain response is a single part", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyStreamWith(conn, func(req map[string]any) []map[string]any {
+			return []map[string]any{{"type": TypeResponse, "action": req["action"], "reply_to": req["request_id"], "payload": "only"}}
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "files.list", nil, "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"only"}, collect(t, stream))
+		assert.NoError(t, stream.Err())
+	})
+
+	t.Run("context cancellation ends stream", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyStreamWith(conn, func(req map[string]any) []map[string]any {
+			return []map[string]any{part(req, 1, "a", false)}
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		ctx, callCancel := context.WithCancel(context.Background())
+		stream, err := client.CallStream(ctx, "logs.tail", nil, "")
+		require.NoError(t, err)
+
+		select {
+		case res := <-stream.Responses():
+			assert.Equal(t, "a", res.Payload)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for first part")
+		}
+		callCancel()
+
+		assert.Empty(t, collect(t, stream))
+		assert.ErrorIs(t, stream.Err(), context.Canceled)
+		assert.Equal(t, 0, pendingCount(client))
+	})
+
+	t.Run("close ends stream", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "logs.tail", nil, "")
+		require.NoError(t, err)
+		stream.Close()
+
+		assert.Empty(t, collect(t, stream))
+		assert.ErrorIs(t, stream.Err(), ErrStreamClosed)
+	})
+
+	t.Run("client close ends stream", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(nil)
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "logs.tail", nil, "")
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+
+		assert.Empty(t, collect(t, stream))
+		assert.ErrorIs(t, stream.Err(), ErrClientClosed)
+
+		_, err = client.CallStream(context.Background(), "logs.tail", nil, "")
+		assert.ErrorIs(t, err, ErrClientClosed)
+	})
+
+	t.Run("undrained stream fails without blocking the client", func(t *testing.T) {
+		router := NewRouter()
+		router.Handle("logs.tail", func(ctx context.Context, req *RequestMessage, res Responder) {
+			stream := res.Stream()
+			for i := 0; i < 300; i++ {
+				if stream.Send(i) != nil {
+					return
+				}
+			}
+			_ = stream.Close()
+		})
+		router.Handle("ping", func(ctx context.Context, req *RequestMessage, res Responder) {
+			_ = res.Reply("pong")
+		})
+		_, api := connectClients(t, ClientConfig{Source: SystemDevice, Router: router}, ClientConfig{Source: SystemAPI})
+
+		stream, err := api.CallStream(context.Background(), "logs.tail", nil, "channel-1")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := api.Call(ctx, "ping", nil, "channel-2")
+		require.NoError(t, err)
+		assert.Equal(t, "pong", resp.Payload)
+
+		require.Eventually(t, func() bool { return pendingCount(api) == 0 }, time.Second, 5*time.Millisecond)
+		parts := collect(t, stream)
+		assert.Less(t, len(parts), 300)
+		assert.ErrorIs(t, stream.Err(), ErrStreamClosed)
+	})
+
+	t.Run("bounds out-of-order parts", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyStreamWith(conn, func(req map[string]any) []map[string]any {
+			var parts []map[string]any
+			for seq := 2; seq < 2+2*streamBufferSize; seq++ {
+				parts = append(parts, part(req, seq, seq, false))
+			}
+			return parts
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "files.list", nil, "")
+		require.NoError(t, err)
+
+		assert.Empty(t, collect(t, stream))
+		assert.ErrorIs(t, stream.Err(), ErrStreamClosed)
+	})
+
+	t.Run("drops duplicate parts", func(t *testing.T) {
+		conn := NewMockConnection()
+		replyStreamWith(conn, func(req map[string]any) []map[string]any {
+			parts := []map[string]any{part(req, 1, "a", false)}
+			for i := 0; i < 10; i++ {
+				parts = append(parts, part(req, 1, "a", false))
+			}
+			return append(parts, part(req, 2, "b", false), part(req, 3, nil, true))
+		})
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		stream, err := client.CallStream(context.Background(), "files.list", nil, "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []any{"a", "b"}, collect(t, stream))
+		assert.NoError(t, stream.Err())
+	})
+
+	t.Run("send failure", func(t *testing.T) {
+		conn := NewMockConnection()
+		conn.On("SendMessage", mock.Anything).Return(errors.New("broken pipe"))
+		client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := client.CallStream(context.Background(), "logs.tail", nil, "")
+		assert.ErrorContains(t, err, "broken pipe")
+		assert.Equal(t, 0, pendingCount(client))
+	})
+}
+
+func TestResponseStream(t *testing.T) {
+	conn := NewMockConnection()
+	sent := captureSent(conn)
+	client, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemDevice})
+	defer cancel()
+
+	req := &RequestMessage{Action: "logs.tail", RequestID: "req-1", ChannelID: "channel-1"}
+	stream := (&responder{client: client, req: req}).Stream()
+
+	require.NoError(t, stream.Send("a"))
+	require.NoError(t, stream.Send("b"))
+	require.NoError(t, stream.Close())
+	assert.ErrorIs(t, stream.Send("c"), ErrStreamClosed)
+	assert.ErrorIs(t, stream.Fail(ErrorResponse{Code: CodeInternal}), ErrStreamClosed)
+
+	for i, want := range []map[string]any{
+		{"seq": float64(1), "payload": "a"},
+		{"seq": float64(2), "payload": "b"},
+		{"seq": float64(3), "end": true},
+	} {
+		envelope := waitSent(t, sent)
+		assert.Equal(t, TypeResponse, envelope["type"], "part %d", i)
+		assert.Equal(t, SystemDevice, envelope["source"])
+		assert.Equal(t, "req-1", envelope["reply_to"])
+		assert.Equal(t, "channel-1", envelope["channel_id"])
+		for key, value := range want {
+			assert.Equal(t, value, envelope[key], "part %d", i)
+		}
+	}
+}
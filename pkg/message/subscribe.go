@@ -0,0 +1,233 @@
+package message
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriptionBufferSize is the capacity of the channel of a
+// subscription when WithSubscriptionBuffer is not used
+const DefaultSubscriptionBufferSize = 100
+
+// EventFilter selects the events delivered to a subscription. Empty fields
+// match every event.
+type EventFilter struct {
+	// Action is an exact action or a pattern such as "camera.*"
+	Action MessageAction
+
+	Source    MessageSource
+	ChannelID ChannelID
+}
+
+// Subscription receives the events matching its filter until Unsubscribe
+// is called or the client is closed
+type Subscription interface {
+	// Events returns the channel the events are delivered to. It is nil for
+	// subscriptions created with WithEventHandler and is closed by Unsubscribe
+	// or when the client is closed.
+	Events() <-chan EventMessage
+
+	// Dropped counts the events discarded because the channel was full
+	Dropped() uint64
+
+	// Unsubscribe detaches the subscription. It is safe to call more than once.
+	Unsubscribe()
+}
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	bufferSize int
+	handler    EventHandler
+}
+
+// WithSubscriptionBuffer sets the capacity of the subscription channel.
+// Events arriving while it is full are dropped.
+func WithSubscriptionBuffer(size int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithEventHandler delivers the events to fn instead of a channel. Like
+// router handlers, fn runs on the Listen goroutine or a dispatcher worker
+// and should not block.
+func WithEventHandler(fn EventHandler) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.handler = fn
+	}
+}
+
+// subscription implements Subscription
+type subscription struct {
+	client  *client
+	filter  EventFilter
+	action  *pattern
+	handler EventHandler
+
+	mutex   sync.Mutex
+	closed  bool
+	ch      chan EventMessage
+	dropped atomic.Uint64
+}
+
+func (s *subscription) Events() <-chan EventMessage {
+	return s.ch
+}
+
+func (s *subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *subscription) Unsubscribe() {
+	s.client.unsubscribe(s)
+	s.close()
+}
+
+// close stops the delivery and closes the channel
+func (s *subscription) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.ch != nil {
+		close(s.ch)
+	}
+}
+
+// match reports whether the event passes the filter
+func (s *subscription) match(event EventMessage, segments []string) bool {
+	if s.filter.Source != "" && s.filter.Source != event.Source {
+		return false
+	}
+	if s.filter.ChannelID != "" && s.filter.ChannelID != event.ChannelID {
+		return false
+	}
+	if s.action != nil {
+		return s.action.match(segments)
+	}
+	return s.filter.Action == "" || s.filter.Action == event.Action
+}
+
+// deliver hands the event to the subscription
+func (s *subscription) deliver(ctx context.Context, event EventMessage) {
+	if s.handler != nil {
+		s.mutex.Lock()
+		closed := s.closed
+		s.mutex.Unlock()
+		if !closed {
+			s.handle(ctx, event)
+		}
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- event:
+	default:
+		s.dropped.Add(1)
+		s.client.logger.WithField("action", event.Action).Warn("Subscription channel full, dropping event")
+	}
+}
+
+// handle runs the event handler, recovering from a panic so that Listen
+// keeps running
+func (s *subscription) handle(ctx context.Context, event EventMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.client.recoverHandler(event, r)
+		}
+	}()
+	s.handler(ctx, &event)
+}
+
+// Subscribe delivers the incoming events matching the filter to a new
+// subscription. Events consumed by a subscription do not reach ReadMessage.
+func (c *client) Subscribe(filter EventFilter, opts ...SubscribeOption) (Subscription, error) {
+	options := subscribeOptions{bufferSize: DefaultSubscriptionBufferSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	sub := &subscription{client: c, filter: filter, handler: options.handler}
+	if isPattern(filter.Action) {
+		p, err := compilePattern(filter.Action)
+		if err != nil {
+			return nil, err
+		}
+		sub.action = &p
+	}
+	if sub.handler == nil {
+		sub.ch = make(chan EventMessage, max(options.bufferSize, 1))
+	}
+
+	c.subscriptionsMutex.Lock()
+	defer c.subscriptionsMutex.Unlock()
+
+	select {
+	case <-c.done:
+		return nil, ErrClientClosed
+	default:
+	}
+	c.subscriptions = append(c.subscriptions, sub)
+	return sub, nil
+}
+
+// unsubscribe removes a subscription from the client
+func (c *client) unsubscribe(sub *subscription) {
+	c.subscriptionsMutex.Lock()
+	defer c.subscriptionsMutex.Unlock()
+	// Copy so that a concurrent publish keeps a consistent snapshot.
+	c.subscriptions = slices.DeleteFunc(slices.Clone(c.subscriptions), func(s *subscription) bool {
+		return s == sub
+	})
+}
+
+// publish delivers an event to the matching subscriptions and reports
+// whether there was any
+func (c *client) publish(ctx context.Context, msg GenericMessage) bool {
+	event, ok := msg.(EventMessage)
+	if !ok {
+		return false
+	}
+
+	c.subscriptionsMutex.RLock()
+	subscriptions := c.subscriptions
+	c.subscriptionsMutex.RUnlock()
+	if len(subscriptions) == 0 {
+		return false
+	}
+
+	segments := strings.Split(event.Action, ".")
+	matched := false
+	for _, sub := range subscriptions {
+		if sub.match(event, segments) {
+			matched = true
+			sub.deliver(ctx, event)
+		}
+	}
+	return matched
+}
+
+// closeSubscriptions closes every subscription when the client is closed
+func (c *client) closeSubscriptions() {
+	c.subscriptionsMutex.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = nil
+	c.subscriptionsMutex.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.close()
+	}
+}
@@ -0,0 +1,195 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pushEvent(conn *MockConnection, action MessageAction, source MessageSource, channelID ChannelID) {
+	pushMessage(conn, map[string]any{
+		"type":       TypeEvent,
+		"action":     action,
+		"source":     source,
+		"channel_id": channelID,
+	})
+}
+
+// receiveEvents reads n events from a subscription
+func receiveEvents(t *testing.T, sub Subscription, n int) []string {
+	t.Helper()
+	var actions []string
+	for i := 0; i < n; i++ {
+		select {
+		case event := <-sub.Events():
+			actions = append(actions, event.Action)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after %d events", i)
+		}
+	}
+	return actions
+}
+
+func assertClosed(t *testing.T, sub Subscription) {
+	t.Helper()
+	select {
+	case _, ok := <-sub.Events():
+		assert.False(t, ok, "subscription channel should be closed")
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for subscription to close")
+	}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	t.Run("filters events", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		camera, err := api.Subscribe(EventFilter{Action: "camera.*"})
+		require.NoError(t, err)
+		channel, err := api.Subscribe(EventFilter{ChannelID: "channel-2", Source: SystemDevice})
+		require.NoError(t, err)
+		exact, err := api.Subscribe(EventFilter{Action: "telemetry"})
+		require.NoError(t, err)
+
+		pushEvent(conn, "camera.zoomed", SystemDevice, "channel-1")
+		pushEvent(conn, "camera.lens.swapped", SystemDevice, "channel-1")
+		pushEvent(conn, "telemetry", SystemDevice, "channel-2")
+		pushEvent(conn, "camera.focused", SystemAPI, "channel-2")
+		pushEvent(conn, "stream.started", SystemDevice, "channel-1")
+
+		assert.Equal(t, []string{"camera.zoomed", "camera.focused"}, receiveEvents(t, camera, 2))
+		assert.Equal(t, []string{"telemetry"}, receiveEvents(t, channel, 1))
+		assert.Equal(t, []string{"telemetry"}, receiveEvents(t, exact, 1))
+
+		// Only events no subscription matched reach ReadMessage.
+		assert.Equal(t, []string{"camera.lens.swapped", "stream.started"}, readN(t, api, 2))
+	})
+
+	t.Run("delivers to callback", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		received := make(chan string, 1)
+		sub, err := api.Subscribe(EventFilter{Action: "stream.**"}, WithEventHandler(func(ctx context.Context, event *EventMessage) {
+			received <- event.Action
+		}))
+		require.NoError(t, err)
+		assert.Nil(t, sub.Events())
+
+		pushEvent(conn, "stream.quality.changed", SystemDevice, "channel-1")
+
+		select {
+		case action := <-received:
+			assert.Equal(t, "stream.quality.changed", action)
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for callback")
+		}
+	})
+
+	t.Run("recovers from panicking callback", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := api.Subscribe(EventFilter{Action: "telemetry"}, WithEventHandler(func(ctx context.Context, event *EventMessage) {
+			panic("bad telemetry")
+		}))
+		require.NoError(t, err)
+
+		pushEvent(conn, "telemetry", SystemDevice, "")
+		pushEvent(conn, "armed", SystemDevice, "")
+
+		assert.Equal(t, []string{"armed"}, readN(t, api, 1))
+		assert.Equal(t, uint64(1), api.Stats().HandlerPanics)
+	})
+
+	t.Run("drops events when the channel is full", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		sub, err := api.Subscribe(EventFilter{Action: "telemetry"}, WithSubscriptionBuffer(2))
+		require.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			pushEvent(conn, "telemetry", SystemDevice, "")
+		}
+		require.Eventually(t, func() bool { return sub.Dropped() == 2 }, time.Second, 5*time.Millisecond)
+		assert.Len(t, receiveEvents(t, sub, 2), 2)
+	})
+
+	t.Run("unsubscribe detaches", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		sub, err := api.Subscribe(EventFilter{Action: "telemetry"})
+		require.NoError(t, err)
+		other, err := api.Subscribe(EventFilter{Action: "telemetry"})
+		require.NoError(t, err)
+
+		sub.Unsubscribe()
+		sub.Unsubscribe()
+		assertClosed(t, sub)
+
+		pushEvent(conn, "telemetry", SystemDevice, "")
+		assert.Equal(t, []string{"telemetry"}, receiveEvents(t, other, 1))
+
+		other.Unsubscribe()
+		pushEvent(conn, "telemetry", SystemDevice, "")
+		assert.Equal(t, []string{"telemetry"}, readN(t, api, 1))
+	})
+
+	t.Run("router still handles subscribed events", func(t *testing.T) {
+		router := NewRouter()
+		handled := make(chan string, 1)
+		router.OnEvent("telemetry", func(ctx context.Context, event *EventMessage) {
+			handled <- event.Action
+		})
+
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI, Router: router})
+		defer cancel()
+
+		sub, err := api.Subscribe(EventFilter{})
+		require.NoError(t, err)
+
+		pushEvent(conn, "telemetry", SystemDevice, "")
+		assert.Equal(t, []string{"telemetry"}, receiveEvents(t, sub, 1))
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for router")
+		}
+	})
+
+	t.Run("close ends subscriptions", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		sub, err := api.Subscribe(EventFilter{})
+		require.NoError(t, err)
+
+		require.NoError(t, api.Close())
+		assertClosed(t, sub)
+
+		_, err = api.Subscribe(EventFilter{})
+		assert.ErrorIs(t, err, ErrClientClosed)
+	})
+
+	t.Run("rejects malformed pattern", func(t *testing.T) {
+		conn := NewMockConnection()
+		api, cancel := newListeningClient(t, conn, ClientConfig{Source: SystemAPI})
+		defer cancel()
+
+		_, err := api.Subscribe(EventFilter{Action: "camera.**.zoom"})
+		assert.Error(t, err)
+	})
+}
@@ -2,6 +2,7 @@ package message
 
 import (
 	"errors"
+	"time"
 )
 
 type MessageType = string
@@ -20,6 +21,23 @@ const (
 	TypeResponse MessageType = "response"
 	TypeError    MessageType = "error"
 	TypeEvent    MessageType = "event"
+
+	// Handshake message types
+	TypeHello   MessageType = "hello"
+	TypeWelcome MessageType = "welcome"
+
+	// TypeCancel asks the peer to stop working on a request
+	TypeCancel MessageType = "cancel"
+
+	// TypeBatch packs several messages into one frame
+	TypeBatch MessageType = "batch"
+
+	// TypeProgress reports the progress of a request being handled
+	TypeProgress MessageType = "progress"
+
+	// Channel lifecycle message types
+	TypeChannelOpen  MessageType = "channel_open"
+	TypeChannelClose MessageType = "channel_close"
 )
 
 // System identifiers
@@ -28,6 +46,19 @@ const (
 	SystemAPI    MessageSource = "api"
 )
 
+// Standard ErrorResponse codes
+const (
+	CodeNotFound         = "not_found"
+	CodeBusy             = "busy"
+	CodeInternal         = "internal"
+	CodeInvalidPayload   = "invalid_payload"
+	CodeIncompatible     = "incompatible"
+	CodeDeadlineExceeded = "deadline_exceeded"
+	CodeOverloaded       = "overloaded"
+	CodeUnknownChannel   = "unknown_channel"
+	CodeChannelClosed    = "channel_closed"
+)
+
 // Protocol validation errors
 var (
 	ErrMissingType        = errors.New("missing required 'type' field")
@@ -38,27 +69,55 @@ var (
 	ErrInvalidSystem      = errors.New("invalid system identifier")
 )
 
+// Client errors
+var (
+	ErrClientClosed     = errors.New("client connection is closed")
+	ErrStreamClosed     = errors.New("response stream is closed")
+	ErrAttemptTimeout   = errors.New("call attempt timed out")
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+	ErrIncompatiblePeer = errors.New("incompatible peer")
+	ErrChannelOpen      = errors.New("channel is already open")
+	ErrUnknownChannel   = errors.New("unknown channel")
+	ErrChannelClosed    = errors.New("channel is closed")
+)
+
 // ErrDeviceNotFound Custom errors for domain operations
 var (
 	ErrDeviceNotFound = errors.New("device not found")
 )
 
-// RequestMessage represents a client request
+// Envelope metadata shared by requests, responses, errors and events:
+// MessageID optionally identifies a single message, Timestamp is the send
+// time in Unix milliseconds set by Client.Send, and Headers carry free-form
+// metadata such as trace IDs.
+
+// RequestMessage represents a client request. TimeoutMs is how long the
+// caller is still willing to wait, in milliseconds, when it set a deadline.
 type RequestMessage struct {
-	Action    MessageAction `json:"action"`
-	Payload   any           `json:"payload,omitempty"`
-	Source    MessageSource `json:"source"`
-	RequestID string        `json:"request_id"`
-	ChannelID string        `json:"channel_id,omitempty"`
+	Action    MessageAction     `json:"action"`
+	Payload   any               `json:"payload,omitempty"`
+	Source    MessageSource     `json:"source"`
+	RequestID string            `json:"request_id"`
+	ChannelID string            `json:"channel_id,omitempty"`
+	TimeoutMs int64             `json:"timeout_ms,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
-// ResponseMessage represents a server response
+// ResponseMessage represents a server response. Streamed responses number
+// their parts from 1 in Sequence and finish with an End marker.
 type ResponseMessage struct {
-	Action    MessageAction `json:"action"`
-	Payload   any           `json:"payload,omitempty"`
-	Source    MessageSource `json:"source"`
-	ChannelID ChannelID     `json:"channel_id,omitempty"`
-	ReplyTo   RequestID     `json:"reply_to"`
+	Action    MessageAction     `json:"action"`
+	Payload   any               `json:"payload,omitempty"`
+	Source    MessageSource     `json:"source"`
+	ChannelID ChannelID         `json:"channel_id,omitempty"`
+	ReplyTo   RequestID         `json:"reply_to"`
+	Sequence  uint64            `json:"seq,omitempty"`
+	End       bool              `json:"end,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
 }
 
 // ErrorResponse represents the error details
@@ -70,22 +129,105 @@ type ErrorResponse struct {
 
 // ErrorMessage represents a server error response
 type ErrorMessage struct {
+	Action    MessageAction     `json:"action"`
+	Source    MessageSource     `json:"source"`
+	ChannelID ChannelID         `json:"channel_id,omitempty"`
+	Error     ErrorResponse     `json:"error"`
+	ReplyTo   RequestID         `json:"reply_to"`
+	MessageID string            `json:"message_id,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// EventMessage represents a server-initiated event
+type EventMessage struct {
+	Action    MessageAction     `json:"action"`
+	Payload   any               `json:"payload,omitempty"`
+	Source    MessageSource     `json:"source"`
+	ChannelID ChannelID         `json:"channel_id,omitempty"`
+	MessageID string            `json:"message_id,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// ProgressMessage reports how far the handling of the request ReplyTo has
+// got. Percent ranges from 0 to 100; Status is free-form.
+type ProgressMessage struct {
 	Action    MessageAction `json:"action"`
 	Source    MessageSource `json:"source"`
 	ChannelID ChannelID     `json:"channel_id,omitempty"`
-	Error     ErrorResponse `json:"error"`
 	ReplyTo   RequestID     `json:"reply_to"`
+	Percent   float64       `json:"percent"`
+	Status    string        `json:"status,omitempty"`
+	Timestamp int64         `json:"timestamp,omitempty"`
 }
 
-// EventMessage represents a server-initiated event
-type EventMessage struct {
-	Action    MessageAction `json:"action"`
-	Payload   any           `json:"payload,omitempty"`
+// CancelMessage asks the peer to abandon the request with RequestID. Without
+// a RequestID every request received on ChannelID is cancelled.
+type CancelMessage struct {
+	RequestID RequestID     `json:"request_id,omitempty"`
 	Source    MessageSource `json:"source"`
 	ChannelID ChannelID     `json:"channel_id,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	Timestamp int64         `json:"timestamp,omitempty"`
+}
+
+// ChannelOpenMessage announces a channel to the peer. The metadata is kept
+// by both sides for as long as the channel is open.
+type ChannelOpenMessage struct {
+	ChannelID ChannelID         `json:"channel_id"`
+	Source    MessageSource     `json:"source"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+}
+
+// ChannelCloseMessage ends a channel. Requests still being handled on it
+// are cancelled.
+type ChannelCloseMessage struct {
+	ChannelID ChannelID     `json:"channel_id"`
+	Source    MessageSource `json:"source"`
+	Reason    string        `json:"reason,omitempty"`
+	Timestamp int64         `json:"timestamp,omitempty"`
+}
+
+// BatchMessage packs several requests, or the replies to them, into a
+// single frame. It is split into its messages when received.
+type BatchMessage struct {
+	Source   MessageSource
+	Messages []GenericMessage
+}
+
+// HelloMessage opens the handshake and advertises what the sender supports
+type HelloMessage struct {
+	ProtocolVersion int           `json:"protocol_version"`
+	SDKVersion      string        `json:"sdk_version"`
+	Source          MessageSource `json:"source"`
+	Codecs          []string      `json:"codecs,omitempty"`
+	Features        []string      `json:"features,omitempty"`
+}
+
+// WelcomeMessage answers a HelloMessage with the negotiated settings, or
+// with an error when the peer is refused
+type WelcomeMessage struct {
+	ProtocolVersion int            `json:"protocol_version"`
+	SDKVersion      string         `json:"sdk_version"`
+	Source          MessageSource  `json:"source"`
+	Codec           string         `json:"codec,omitempty"`
+	Features        []string       `json:"features,omitempty"`
+	Error           *ErrorResponse `json:"error,omitempty"`
 }
 
 // Channel represents a communication channel
 type Channel struct {
 	ID ChannelID `json:"id"`
+
+	// Opener is the source of the side that opened the channel
+	Opener MessageSource `json:"opener,omitempty"`
+
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	LastActivity time.Time         `json:"last_activity"`
+
+	// LastReceived is when the peer last sent a message on the channel
+	LastReceived time.Time `json:"last_received"`
 }
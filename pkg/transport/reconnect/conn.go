@@ -0,0 +1,385 @@
+// Package reconnect provides a message.Connection decorator that redials
+// dropped connections so that a message.Client survives flaky links.
+package reconnect
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+// Default reconnect settings
+const (
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+	DefaultMultiplier     = 2.0
+	DefaultJitter         = 0.2
+	DefaultBufferSize     = 256
+	DefaultReadBuffer     = 256
+)
+
+// Reconnect errors
+var (
+	ErrConnectionClosed = errors.New("reconnecting connection is closed")
+	ErrBufferFull       = errors.New("outbound buffer is full")
+)
+
+// DialFunc establishes a new underlying connection
+type DialFunc func(ctx context.Context) (message.Connection, error)
+
+// Config holds the reconnect settings. Zero values are replaced by the
+// defaults above.
+type Config struct {
+	// InitialBackoff is the delay before the first redial attempt
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between redial attempts
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after every failed attempt
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to this fraction of its value
+	Jitter float64
+
+	// MaxAttempts is the number of consecutive failed dials after which the
+	// connection gives up and closes. Zero means retry forever.
+	MaxAttempts int
+
+	// BufferSize is the maximum number of outbound messages kept while
+	// disconnected
+	BufferSize int
+
+	// ReadBuffer is the capacity of the channel returned by ReadMessage
+	ReadBuffer int
+
+	// OnConnect is called once the first connection is established
+	OnConnect func()
+
+	// OnDisconnect is called when an established connection drops
+	OnDisconnect func()
+
+	// OnReconnect is called after a dropped connection is re-established
+	// and the buffered messages are flushed
+	OnReconnect func(attempts int)
+}
+
+func (c Config) withDefaults() Config {
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = DefaultInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.Multiplier < 1 {
+		c.Multiplier = DefaultMultiplier
+	}
+	if c.Jitter < 0 || c.Jitter > 1 {
+		c.Jitter = DefaultJitter
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultBufferSize
+	}
+	if c.ReadBuffer <= 0 {
+		c.ReadBuffer = DefaultReadBuffer
+	}
+	return c
+}
+
+// Conn is a message.Connection that transparently redials its underlying
+// connection. ReadMessage returns the same channel across reconnects and
+// outbound messages are buffered while disconnected.
+//
+// The peer behind a redialed link starts a fresh session: the callbacks
+// registered with NotifyReconnect run before the buffered messages are
+// flushed, and the messages encoded with a codec the new session no longer
+// uses are dropped.
+type Conn struct {
+	dial   DialFunc
+	config Config
+	logger *log.Entry
+
+	msgCh  chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex       sync.Mutex
+	conn        message.Connection
+	codec       message.Codec
+	pending     []pendingMessage
+	closed      bool
+	onReconnect []func()
+}
+
+// pendingMessage is an outbound message buffered while disconnected along
+// with the codec it was encoded with
+type pendingMessage struct {
+	data  []byte
+	codec message.Codec
+}
+
+var (
+	_ message.Connection        = (*Conn)(nil)
+	_ message.CodecDeclarer     = (*Conn)(nil)
+	_ message.CodecSwitcher     = (*Conn)(nil)
+	_ message.ReconnectNotifier = (*Conn)(nil)
+)
+
+// Dial establishes the first connection, retrying with backoff, and keeps it
+// alive until Close is called. ctx only bounds the initial attempts.
+func Dial(ctx context.Context, logger *log.Entry, dial DialFunc, config Config) (*Conn, error) {
+	config = config.withDefaults()
+	connCtx, cancel := context.WithCancel(context.Background())
+	c := &Conn{
+		dial:   dial,
+		config: config,
+		logger: logger.WithField("component", "reconnect"),
+		msgCh:  make(chan []byte, config.ReadBuffer),
+		ctx:    connCtx,
+		cancel: cancel,
+	}
+
+	// Stop the initial attempts when either context is done.
+	stop := context.AfterFunc(ctx, cancel)
+	conn, _, err := c.connect()
+	stop()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c.conn = conn
+	if config.OnConnect != nil {
+		config.OnConnect()
+	}
+
+	go c.run(conn)
+	return c, nil
+}
+
+// SendMessage writes the message to the current connection, or buffers it
+// while disconnected. ErrBufferFull is returned once the buffer is full.
+func (c *Conn) SendMessage(data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	if c.conn != nil {
+		err := c.conn.SendMessage(data)
+		if err == nil {
+			return nil
+		}
+		// Treat a failed write as a dropped link and let the reader redial.
+		c.logger.WithError(err).Debug("Send failed, buffering until reconnected")
+		_ = c.conn.Close()
+	}
+
+	if len(c.pending) >= c.config.BufferSize {
+		return ErrBufferFull
+	}
+	c.pending = append(c.pending, pendingMessage{data: append([]byte(nil), data...), codec: c.codec})
+	return nil
+}
+
+// Codec returns the codec declared by the current connection, nil when it
+// declares none
+func (c *Conn) Codec() message.Codec {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if declarer, ok := c.conn.(message.CodecDeclarer); ok {
+		return declarer.Codec()
+	}
+	return nil
+}
+
+// SetCodec records the codec of outbound messages and passes it on to the
+// current connection and the ones redialed later
+func (c *Conn) SetCodec(codec message.Codec) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.codec = codec
+	if switcher, ok := c.conn.(message.CodecSwitcher); ok {
+		switcher.SetCodec(codec)
+	}
+}
+
+// NotifyReconnect registers fn to be called whenever a dropped connection is
+// redialed, before the buffered messages are flushed
+func (c *Conn) NotifyReconnect(fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// ReadMessage returns the channel of incoming messages. It stays open across
+// reconnects and is closed by Close or when reconnecting gives up.
+func (c *Conn) ReadMessage() <-chan []byte {
+	return c.msgCh
+}
+
+// Close stops reconnecting and closes the current connection
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.cancel()
+	conn := c.conn
+	c.pending = nil
+	c.mutex.Unlock()
+
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// IsClosed returns whether the connection is closed for good
+func (c *Conn) IsClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// run forwards incoming messages and redials whenever the connection drops
+func (c *Conn) run(conn message.Connection) {
+	defer close(c.msgCh)
+
+	for {
+		if !c.forward(conn) {
+			return
+		}
+
+		c.mutex.Lock()
+		c.conn = nil
+		closed := c.closed
+		c.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		c.logger.Warn("Connection lost, reconnecting")
+		if c.config.OnDisconnect != nil {
+			c.config.OnDisconnect()
+		}
+
+		var attempts int
+		var err error
+		conn, attempts, err = c.connect()
+		if err != nil {
+			c.logger.WithError(err).Error("Giving up reconnecting")
+			_ = c.Close()
+			return
+		}
+
+		c.mutex.Lock()
+		callbacks := slices.Clone(c.onReconnect)
+		c.mutex.Unlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+
+		if !c.resume(conn) {
+			_ = conn.Close()
+			return
+		}
+
+		c.logger.WithField("attempts", attempts).Info("Reconnected")
+		if c.config.OnReconnect != nil {
+			c.config.OnReconnect(attempts)
+		}
+	}
+}
+
+// forward copies messages from conn until it closes. It returns false when
+// the decorator itself was closed meanwhile.
+func (c *Conn) forward(conn message.Connection) bool {
+	for data := range conn.ReadMessage() {
+		select {
+		case c.msgCh <- data:
+		case <-c.ctx.Done():
+			_ = conn.Close()
+			return false
+		}
+	}
+	return true
+}
+
+// resume installs a new connection and flushes the messages buffered while
+// disconnected. It returns false if the decorator was closed meanwhile.
+func (c *Conn) resume(conn message.Connection) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if switcher, ok := conn.(message.CodecSwitcher); ok && c.codec != nil {
+		switcher.SetCodec(c.codec)
+	}
+	c.pending = slices.DeleteFunc(c.pending, func(msg pendingMessage) bool {
+		if msg.codec == nil || c.codec == nil || msg.codec.Name() == c.codec.Name() {
+			return false
+		}
+		c.logger.WithField("codec", msg.codec.Name()).Warn("Dropping buffered message encoded for the previous session")
+		return true
+	})
+
+	for len(c.pending) > 0 {
+		if err := conn.SendMessage(c.pending[0].data); err != nil {
+			c.logger.WithError(err).Warn("Failed to flush buffered messages")
+			break
+		}
+		c.pending = c.pending[1:]
+	}
+	c.conn = conn
+	return true
+}
+
+// connect dials until it succeeds, the attempts are exhausted or the
+// connection is closed, and reports how many attempts it took
+func (c *Conn) connect() (message.Connection, int, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial(c.ctx)
+		if err == nil {
+			return conn, attempt, nil
+		}
+		c.logger.WithError(err).WithField("attempt", attempt).Debug("Dial failed")
+
+		if c.config.MaxAttempts > 0 && attempt >= c.config.MaxAttempts {
+			return nil, attempt, err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, attempt, c.ctx.Err()
+		}
+	}
+}
+
+// backoff returns the delay after the given failed attempt
+func (c *Conn) backoff(attempt int) time.Duration {
+	delay := float64(c.config.InitialBackoff) * math.Pow(c.config.Multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(c.config.MaxBackoff))
+	delay += delay * c.config.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
@@ -0,0 +1,363 @@
+package reconnect
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+// fakeConn is an in-memory connection whose peer side is driven by the test
+type fakeConn struct {
+	msgCh  chan []byte
+	sent   chan []byte
+	mutex  sync.Mutex
+	closed bool
+	codec  message.Codec
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		msgCh: make(chan []byte, 100),
+		sent:  make(chan []byte, 100),
+	}
+}
+
+func (f *fakeConn) SendMessage(data []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.closed {
+		return errors.New("closed")
+	}
+	f.sent <- data
+	return nil
+}
+
+func (f *fakeConn) ReadMessage() <-chan []byte {
+	return f.msgCh
+}
+
+func (f *fakeConn) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.msgCh)
+	}
+	return nil
+}
+
+func (f *fakeConn) IsClosed() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.closed
+}
+
+func (f *fakeConn) SetCodec(codec message.Codec) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.codec = codec
+}
+
+func (f *fakeConn) currentCodec() message.Codec {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.codec
+}
+
+// peerConn is the far end of a fakeConn, for a client acting as the peer
+type peerConn struct {
+	*fakeConn
+}
+
+func (p peerConn) SendMessage(data []byte) error {
+	p.msgCh <- data
+	return nil
+}
+
+func (p peerConn) ReadMessage() <-chan []byte {
+	return p.sent
+}
+
+func (p peerConn) Close() error {
+	return nil
+}
+
+func (p peerConn) IsClosed() bool {
+	return false
+}
+
+// fakeDialer hands out connections from a queue and fails when it is empty
+type fakeDialer struct {
+	conns chan *fakeConn
+	dials atomic.Int32
+}
+
+func newFakeDialer(conns ...*fakeConn) *fakeDialer {
+	d := &fakeDialer{conns: make(chan *fakeConn, 10)}
+	for _, conn := range conns {
+		d.conns <- conn
+	}
+	return d
+}
+
+func (d *fakeDialer) Dial(ctx context.Context) (message.Connection, error) {
+	d.dials.Add(1)
+	select {
+	case conn := <-d.conns:
+		return conn, nil
+	default:
+		return nil, errors.New("network unreachable")
+	}
+}
+
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logrus.NewEntry(logger)
+}
+
+var fastRetry = Config{
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+}
+
+func receive(t *testing.T, ch <-chan []byte) string {
+	t.Helper()
+	select {
+	case data, ok := <-ch:
+		require.True(t, ok, "channel closed")
+		return string(data)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+		return ""
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Run("retries until connected", func(t *testing.T) {
+		dialer := newFakeDialer()
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			dialer.conns <- newFakeConn()
+		}()
+
+		connected := make(chan struct{}, 1)
+		config := fastRetry
+		config.OnConnect = func() { connected <- struct{}{} }
+
+		conn, err := Dial(context.Background(), testLogger(), dialer.Dial, config)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Greater(t, dialer.dials.Load(), int32(1))
+		assert.Len(t, connected, 1)
+	})
+
+	t.Run("fails after max attempts", func(t *testing.T) {
+		config := fastRetry
+		config.MaxAttempts = 3
+
+		dialer := newFakeDialer()
+		_, err := Dial(context.Background(), testLogger(), dialer.Dial, config)
+		assert.EqualError(t, err, "network unreachable")
+		assert.Equal(t, int32(3), dialer.dials.Load())
+	})
+
+	t.Run("stops when context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		_, err := Dial(ctx, testLogger(), newFakeDialer().Dial, fastRetry)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestConn_Reconnect(t *testing.T) {
+	first, second := newFakeConn(), newFakeConn()
+	dialer := newFakeDialer(first)
+
+	disconnected := make(chan struct{}, 1)
+	reconnected := make(chan int, 1)
+	config := fastRetry
+	config.OnDisconnect = func() { disconnected <- struct{}{} }
+	config.OnReconnect = func(attempts int) { reconnected <- attempts }
+
+	conn, err := Dial(context.Background(), testLogger(), dialer.Dial, config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	messages := conn.ReadMessage()
+	first.msgCh <- []byte("before")
+	assert.Equal(t, "before", receive(t, messages))
+
+	// Drop the link and buffer messages during the outage
+	_ = first.Close()
+	<-disconnected
+	require.NoError(t, conn.SendMessage([]byte("queued-1")))
+	require.NoError(t, conn.SendMessage([]byte("queued-2")))
+
+	dialer.conns <- second
+	select {
+	case attempts := <-reconnected:
+		assert.GreaterOrEqual(t, attempts, 1)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for reconnect")
+	}
+
+	assert.Equal(t, "queued-1", receive(t, second.sent))
+	assert.Equal(t, "queued-2", receive(t, second.sent))
+
+	second.msgCh <- []byte("after")
+	assert.Equal(t, "after", receive(t, messages))
+	assert.False(t, conn.IsClosed())
+}
+
+func TestConn_BufferLimit(t *testing.T) {
+	first := newFakeConn()
+	disconnected := make(chan struct{}, 1)
+	config := fastRetry
+	config.BufferSize = 2
+	config.OnDisconnect = func() { disconnected <- struct{}{} }
+
+	conn, err := Dial(context.Background(), testLogger(), newFakeDialer(first).Dial, config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_ = first.Close()
+	<-disconnected
+
+	require.NoError(t, conn.SendMessage([]byte("1")))
+	require.NoError(t, conn.SendMessage([]byte("2")))
+	assert.ErrorIs(t, conn.SendMessage([]byte("3")), ErrBufferFull)
+}
+
+func TestConn_GivesUp(t *testing.T) {
+	first := newFakeConn()
+	config := fastRetry
+	config.MaxAttempts = 2
+
+	conn, err := Dial(context.Background(), testLogger(), newFakeDialer(first).Dial, config)
+	require.NoError(t, err)
+
+	_ = first.Close()
+
+	select {
+	case _, ok := <-conn.ReadMessage():
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for read channel to close")
+	}
+	assert.True(t, conn.IsClosed())
+	assert.ErrorIs(t, conn.SendMessage([]byte("late")), ErrConnectionClosed)
+}
+
+func TestConn_Close(t *testing.T) {
+	first := newFakeConn()
+	dialer := newFakeDialer(first)
+
+	conn, err := Dial(context.Background(), testLogger(), dialer.Dial, fastRetry)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+	assert.True(t, first.IsClosed())
+
+	select {
+	case _, ok := <-conn.ReadMessage():
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for read channel to close")
+	}
+
+	// No redial happens after an explicit close
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), dialer.dials.Load())
+	assert.NoError(t, conn.Close())
+}
+
+func TestConn_KeepsClientListening(t *testing.T) {
+	first, second := newFakeConn(), newFakeConn()
+	dialer := newFakeDialer(first, second)
+
+	conn, err := Dial(context.Background(), testLogger(), dialer.Dial, fastRetry)
+	require.NoError(t, err)
+
+	client := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemDevice})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = client.Listen(ctx) }()
+
+	_ = first.Close()
+	second.msgCh <- []byte(`{"type":"event","action":"ping"}`)
+
+	select {
+	case msg := <-client.ReadMessage():
+		event, ok := msg.(message.EventMessage)
+		require.True(t, ok)
+		assert.Equal(t, "ping", event.Action)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message after reconnect")
+	}
+	assert.False(t, client.IsClosed())
+}
+
+func TestConn_RestartsHandshake(t *testing.T) {
+	first, second := newFakeConn(), newFakeConn()
+	dialer := newFakeDialer(first)
+
+	conn, err := Dial(context.Background(), testLogger(), dialer.Dial, fastRetry)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handshake := &message.HandshakeConfig{Codecs: []message.Codec{message.MsgpackCodec, message.JSONCodec}}
+	listen := func(conn message.Connection, config message.ClientConfig) message.Client {
+		client := message.NewClient(testLogger(), conn, config)
+		go func() { _ = client.Listen(ctx) }()
+		return client
+	}
+	awaitCodec := func(client message.Client) string {
+		t.Helper()
+		awaitCtx, awaitCancel := context.WithTimeout(ctx, time.Second)
+		defer awaitCancel()
+		negotiation, err := client.AwaitNegotiation(awaitCtx)
+		require.NoError(t, err)
+		return negotiation.Codec.Name()
+	}
+
+	initiator := *handshake
+	initiator.Initiate = true
+	device := listen(conn, message.ClientConfig{Source: message.SystemDevice, Handshake: &initiator})
+	listen(peerConn{first}, message.ClientConfig{Source: message.SystemAPI, Handshake: handshake})
+	assert.Equal(t, message.CodecMsgpack, awaitCodec(device))
+	assert.Equal(t, message.MsgpackCodec, first.currentCodec())
+
+	// The redialed peer starts a new session expecting a hello in JSON
+	_ = first.Close()
+	api := listen(peerConn{second}, message.ClientConfig{Source: message.SystemAPI, Handshake: handshake})
+	dialer.conns <- second
+	assert.Equal(t, message.CodecMsgpack, awaitCodec(api))
+	require.Eventually(t, func() bool {
+		negotiation := device.Negotiation()
+		return negotiation != nil && negotiation.Codec == message.MsgpackCodec
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, message.MsgpackCodec, second.currentCodec())
+
+	require.NoError(t, device.SendEventToChannel("telemetry", "ok", "channel-1"))
+	select {
+	case msg := <-api.ReadMessage():
+		event, ok := msg.(message.EventMessage)
+		require.True(t, ok)
+		assert.Equal(t, "telemetry", event.Action)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event after reconnect")
+	}
+}
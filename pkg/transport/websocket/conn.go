@@ -0,0 +1,243 @@
+// Package websocket implements message.Connection on top of WebSocket,
+// with a dialer for devices and an http.Handler for the API side.
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+// Default connection settings
+const (
+	DefaultReadLimit    = 1 << 20
+	DefaultPingInterval = 30 * time.Second
+	DefaultPongWait     = 60 * time.Second
+	DefaultWriteWait    = 10 * time.Second
+	DefaultReadBuffer   = 256
+)
+
+// ErrConnectionClosed is returned when writing to a closed connection
+var ErrConnectionClosed = errors.New("websocket connection is closed")
+
+// Config holds the settings shared by dialed and accepted connections.
+// Zero values are replaced by the defaults above.
+type Config struct {
+	// ReadLimit is the maximum size in bytes of an incoming message
+	ReadLimit int64
+
+	// PingInterval is how often pings are sent to the peer
+	PingInterval time.Duration
+
+	// PongWait is how long to wait for a message or a pong from the peer
+	// before the connection is considered dead. It must be longer than
+	// PingInterval.
+	PongWait time.Duration
+
+	// WriteWait is the deadline for a single write
+	WriteWait time.Duration
+
+	// ReadBuffer is the capacity of the channel returned by ReadMessage
+	ReadBuffer int
+}
+
+func (c Config) withDefaults() Config {
+	if c.ReadLimit <= 0 {
+		c.ReadLimit = DefaultReadLimit
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = DefaultPingInterval
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = DefaultPongWait
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = DefaultWriteWait
+	}
+	if c.ReadBuffer <= 0 {
+		c.ReadBuffer = DefaultReadBuffer
+	}
+	return c
+}
+
+// Conn is a WebSocket connection implementing message.Connection
+type Conn struct {
+	ws     *ws.Conn
+	config Config
+	logger *log.Entry
+
+	codecMutex sync.Mutex
+	codec      message.Codec
+	frameType  int
+
+	msgCh      chan []byte
+	done       chan struct{}
+	readDone   chan struct{}
+	writeMutex sync.Mutex
+	closed     bool
+	closeMutex sync.Mutex
+}
+
+var (
+	_ message.Connection    = (*Conn)(nil)
+	_ message.CodecDeclarer = (*Conn)(nil)
+	_ message.CodecSwitcher = (*Conn)(nil)
+)
+
+// newConn wraps an established WebSocket and starts its read and ping loops.
+// The codec is taken from the negotiated subprotocol, JSON otherwise.
+func newConn(logger *log.Entry, conn *ws.Conn, config Config) *Conn {
+	config = config.withDefaults()
+
+	codec, ok := message.LookupCodec(conn.Subprotocol())
+	if !ok {
+		codec = message.JSONCodec
+	}
+
+	c := &Conn{
+		ws:       conn,
+		config:   config,
+		logger:   logger.WithField("component", "websocket"),
+		msgCh:    make(chan []byte, config.ReadBuffer),
+		done:     make(chan struct{}),
+		readDone: make(chan struct{}),
+	}
+	c.SetCodec(codec)
+
+	conn.SetReadLimit(config.ReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.PongWait))
+	})
+
+	go c.readLoop()
+	go c.pingLoop()
+	return c
+}
+
+// Codec returns the codec negotiated for this connection
+func (c *Conn) Codec() message.Codec {
+	c.codecMutex.Lock()
+	defer c.codecMutex.Unlock()
+	return c.codec
+}
+
+// SetCodec switches the codec of the connection, sending text frames for
+// JSON and binary frames otherwise
+func (c *Conn) SetCodec(codec message.Codec) {
+	frameType := ws.BinaryMessage
+	if codec.Name() == message.CodecJSON {
+		frameType = ws.TextMessage
+	}
+
+	c.codecMutex.Lock()
+	defer c.codecMutex.Unlock()
+	c.codec = codec
+	c.frameType = frameType
+}
+
+// SendMessage writes a text message, or a binary one for binary codecs.
+// Writes are serialized so it is safe to call from several goroutines.
+func (c *Conn) SendMessage(data []byte) error {
+	if c.IsClosed() {
+		return ErrConnectionClosed
+	}
+
+	c.codecMutex.Lock()
+	frameType := c.frameType
+	c.codecMutex.Unlock()
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	_ = c.ws.SetWriteDeadline(time.Now().Add(c.config.WriteWait))
+	if err := c.ws.WriteMessage(frameType, data); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+// ReadMessage returns the channel of incoming messages. It is closed once
+// the connection is closed by either side.
+func (c *Conn) ReadMessage() <-chan []byte {
+	return c.msgCh
+}
+
+// Close sends a normal closure frame, waits briefly for the peer to answer
+// it and closes the underlying connection
+func (c *Conn) Close() error {
+	c.closeMutex.Lock()
+	if c.closed {
+		c.closeMutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.closeMutex.Unlock()
+
+	closeFrame := ws.FormatCloseMessage(ws.CloseNormalClosure, "")
+	if err := c.ws.WriteControl(ws.CloseMessage, closeFrame, time.Now().Add(c.config.WriteWait)); err == nil {
+		select {
+		case <-c.readDone:
+		case <-time.After(c.config.WriteWait):
+		}
+	}
+	return c.ws.Close()
+}
+
+// IsClosed returns whether the connection is closed
+func (c *Conn) IsClosed() bool {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	return c.closed
+}
+
+// readLoop forwards incoming messages until the connection fails or closes
+func (c *Conn) readLoop() {
+	defer close(c.msgCh)
+	defer func() { _ = c.Close() }()
+	defer close(c.readDone)
+
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			if ws.IsUnexpectedCloseError(err, ws.CloseNormalClosure, ws.CloseGoingAway) && !c.IsClosed() {
+				c.logger.WithError(err).Warn("WebSocket closed unexpectedly")
+			}
+			return
+		}
+		// Incoming messages prove the peer alive just like pongs do.
+		_ = c.ws.SetReadDeadline(time.Now().Add(c.config.PongWait))
+
+		select {
+		case c.msgCh <- data:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// pingLoop keeps the connection alive and detects dead peers
+func (c *Conn) pingLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.ws.WriteControl(ws.PingMessage, nil, time.Now().Add(c.config.WriteWait)); err != nil {
+				c.logger.WithError(err).Debug("Failed to send ping")
+				_ = c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+func testLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	return logrus.NewEntry(logger)
+}
+
+// startServer serves WebSocket connections and hands each one to onConnect
+func startServer(t *testing.T, config HandlerConfig, onConnect ConnectFunc) string {
+	t.Helper()
+	server := httptest.NewServer(NewHandler(testLogger(), config, onConnect))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// echo sends back every message received on conn
+func echo(conn *Conn, _ *http.Request) {
+	for data := range conn.ReadMessage() {
+		_ = conn.SendMessage(data)
+	}
+}
+
+func waitClosed(t *testing.T, ch <-chan []byte) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for connection to close")
+		}
+	}
+}
+
+func TestConn_SendAndReceive(t *testing.T) {
+	url := startServer(t, HandlerConfig{}, echo)
+
+	conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SendMessage([]byte(`{"hello":"world"}`)))
+
+	select {
+	case data := <-conn.ReadMessage():
+		assert.Equal(t, `{"hello":"world"}`, string(data))
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for echo")
+	}
+}
+
+func TestConn_ConcurrentWrites(t *testing.T) {
+	url := startServer(t, HandlerConfig{}, echo)
+
+	conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	const writers, perWriter = 10, 50
+	for i := 0; i < writers; i++ {
+		go func() {
+			for j := 0; j < perWriter; j++ {
+				_ = conn.SendMessage([]byte("ping"))
+			}
+		}()
+	}
+
+	for i := 0; i < writers*perWriter; i++ {
+		select {
+		case data := <-conn.ReadMessage():
+			assert.Equal(t, "ping", string(data))
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout after %d messages", i)
+		}
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	t.Run("local close closes both sides", func(t *testing.T) {
+		serverConn := make(chan *Conn, 1)
+		url := startServer(t, HandlerConfig{}, func(conn *Conn, _ *http.Request) {
+			serverConn <- conn
+		})
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+		require.NoError(t, err)
+		peer := <-serverConn
+
+		require.NoError(t, conn.Close())
+		assert.True(t, conn.IsClosed())
+		assert.ErrorIs(t, conn.SendMessage([]byte("late")), ErrConnectionClosed)
+
+		waitClosed(t, conn.ReadMessage())
+		waitClosed(t, peer.ReadMessage())
+		assert.True(t, peer.IsClosed())
+	})
+
+	t.Run("repeated close is a no-op", func(t *testing.T) {
+		url := startServer(t, HandlerConfig{}, echo)
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+		require.NoError(t, err)
+
+		require.NoError(t, conn.Close())
+		assert.NoError(t, conn.Close())
+	})
+}
+
+func TestConn_ReadLimit(t *testing.T) {
+	serverConn := make(chan *Conn, 1)
+	url := startServer(t, HandlerConfig{Config: Config{ReadLimit: 16}}, func(conn *Conn, _ *http.Request) {
+		serverConn <- conn
+	})
+
+	conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+	require.NoError(t, err)
+	defer conn.Close()
+	peer := <-serverConn
+
+	require.NoError(t, conn.SendMessage([]byte(strings.Repeat("x", 64))))
+
+	waitClosed(t, peer.ReadMessage())
+	waitClosed(t, conn.ReadMessage())
+}
+
+func TestConn_Keepalive(t *testing.T) {
+	t.Run("pings keep idle connection open", func(t *testing.T) {
+		keepalive := Config{PingInterval: 20 * time.Millisecond, PongWait: 60 * time.Millisecond}
+		url := startServer(t, HandlerConfig{Config: keepalive}, echo)
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{Config: keepalive})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		time.Sleep(200 * time.Millisecond)
+		assert.False(t, conn.IsClosed())
+		require.NoError(t, conn.SendMessage([]byte("still here")))
+
+		select {
+		case data := <-conn.ReadMessage():
+			assert.Equal(t, "still here", string(data))
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for echo")
+		}
+	})
+
+	t.Run("messages keep connection open without pongs", func(t *testing.T) {
+		serverConn := make(chan *Conn, 1)
+		keepalive := Config{PingInterval: 20 * time.Millisecond, PongWait: 60 * time.Millisecond}
+		url := startServer(t, HandlerConfig{Config: keepalive}, func(conn *Conn, _ *http.Request) {
+			serverConn <- conn
+		})
+
+		// A raw connection that never reads never answers pings
+		raw, _, err := ws.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer raw.Close()
+
+		peer := <-serverConn
+		for range 20 {
+			require.NoError(t, raw.WriteMessage(ws.TextMessage, []byte("telemetry")))
+			assert.Equal(t, "telemetry", string(<-peer.ReadMessage()))
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.False(t, peer.IsClosed())
+	})
+
+	t.Run("unresponsive peer is disconnected", func(t *testing.T) {
+		serverConn := make(chan *Conn, 1)
+		keepalive := Config{PingInterval: 20 * time.Millisecond, PongWait: 60 * time.Millisecond}
+		url := startServer(t, HandlerConfig{Config: keepalive}, func(conn *Conn, _ *http.Request) {
+			serverConn <- conn
+		})
+
+		// A raw connection that never reads never answers pings
+		raw, _, err := ws.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer raw.Close()
+
+		peer := <-serverConn
+		waitClosed(t, peer.ReadMessage())
+		assert.True(t, peer.IsClosed())
+	})
+}
+
+func TestConn_CheckOrigin(t *testing.T) {
+	url := startServer(t, HandlerConfig{}, echo)
+
+	header := http.Header{"Origin": []string{"https://evil.example"}}
+	_, err := Dial(context.Background(), testLogger(), url, DialConfig{Header: header})
+	assert.ErrorContains(t, err, "status 403")
+}
+
+func TestConn_MessageClient(t *testing.T) {
+	router := message.NewRouter()
+	router.Handle("camera.zoom", func(ctx context.Context, req *message.RequestMessage, res message.Responder) {
+		_ = res.Reply(req.Payload)
+	})
+
+	url := startServer(t, HandlerConfig{}, func(conn *Conn, r *http.Request) {
+		device := message.NewClient(testLogger(), conn, message.ClientConfig{
+			Source: message.SystemDevice,
+			Router: router,
+		})
+		_ = device.Listen(r.Context())
+	})
+
+	conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+	require.NoError(t, err)
+
+	api := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemAPI})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = api.Listen(ctx) }()
+
+	callCtx, callCancel := context.WithTimeout(ctx, time.Second)
+	defer callCancel()
+	resp, err := api.Call(callCtx, "camera.zoom", map[string]any{"level": 2}, "channel-1")
+	require.NoError(t, err)
+	assert.Equal(t, message.SystemDevice, resp.Source)
+	assert.Equal(t, map[string]any{"level": float64(2)}, resp.Payload)
+}
+
+func TestConn_CodecNegotiation(t *testing.T) {
+	t.Run("uses codec accepted by server", func(t *testing.T) {
+		serverCodec := make(chan message.Codec, 1)
+		url := startServer(t, HandlerConfig{Codecs: []message.Codec{message.CBORCodec, message.MsgpackCodec}}, func(conn *Conn, _ *http.Request) {
+			serverCodec <- conn.Codec()
+			echo(conn, nil)
+		})
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{Codecs: []message.Codec{message.MsgpackCodec}})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, message.CodecMsgpack, conn.Codec().Name())
+		assert.Equal(t, message.CodecMsgpack, (<-serverCodec).Name())
+	})
+
+	t.Run("falls back to json", func(t *testing.T) {
+		url := startServer(t, HandlerConfig{}, echo)
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{Codecs: []message.Codec{message.CBORCodec}})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, message.CodecJSON, conn.Codec().Name())
+	})
+
+	t.Run("clients exchange binary messages", func(t *testing.T) {
+		codecs := []message.Codec{message.CBORCodec}
+		url := startServer(t, HandlerConfig{Codecs: codecs}, func(conn *Conn, r *http.Request) {
+			router := message.NewRouter()
+			router.Handle("echo", func(ctx context.Context, req *message.RequestMessage, res message.Responder) {
+				_ = res.Reply(req.Payload)
+			})
+			device := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemDevice, Router: router})
+			_ = device.Listen(r.Context())
+		})
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{Codecs: codecs})
+		require.NoError(t, err)
+
+		api := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemAPI})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() { _ = api.Listen(ctx) }()
+
+		resp, err := api.Call(ctx, "echo", map[string]any{"armed": true}, "")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"armed": true}, resp.Payload)
+	})
+	t.Run("follows codec switched by the handshake", func(t *testing.T) {
+		handshake := &message.HandshakeConfig{Codecs: []message.Codec{message.MsgpackCodec, message.JSONCodec}}
+		serverConn := make(chan *Conn, 1)
+		url := startServer(t, HandlerConfig{}, func(conn *Conn, r *http.Request) {
+			router := message.NewRouter()
+			router.Handle("echo", func(ctx context.Context, req *message.RequestMessage, res message.Responder) {
+				_ = res.Reply(req.Payload)
+			})
+			serverConn <- conn
+			device := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemDevice, Router: router, Handshake: handshake})
+			_ = device.Listen(r.Context())
+		})
+
+		conn, err := Dial(context.Background(), testLogger(), url, DialConfig{})
+		require.NoError(t, err)
+
+		initiate := *handshake
+		initiate.Initiate = true
+		api := message.NewClient(testLogger(), conn, message.ClientConfig{Source: message.SystemAPI, Handshake: &initiate})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() { _ = api.Listen(ctx) }()
+
+		negotiation, err := api.AwaitNegotiation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, message.CodecMsgpack, negotiation.Codec.Name())
+
+		resp, err := api.Call(ctx, "echo", map[string]any{"armed": true}, "")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"armed": true}, resp.Payload)
+
+		for _, c := range []*Conn{conn, <-serverConn} {
+			assert.Equal(t, message.CodecMsgpack, c.Codec().Name())
+			c.codecMutex.Lock()
+			assert.Equal(t, ws.BinaryMessage, c.frameType)
+			c.codecMutex.Unlock()
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+// DialConfig holds the settings used by devices to connect to the API
+type DialConfig struct {
+	Config
+
+	// Header is sent with the opening handshake, e.g. for authentication
+	Header http.Header
+
+	// Dialer overrides the default WebSocket dialer
+	Dialer *ws.Dialer
+
+	// Codecs are offered to the server as subprotocols, in order of
+	// preference. JSON is used when none is accepted.
+	Codecs []message.Codec
+}
+
+// Dial connects to the WebSocket endpoint at url
+func Dial(ctx context.Context, logger *log.Entry, url string, config DialConfig) (*Conn, error) {
+	dialer := ws.DefaultDialer
+	if config.Dialer != nil {
+		dialer = config.Dialer
+	}
+	if len(config.Codecs) > 0 {
+		withCodecs := *dialer
+		withCodecs.Subprotocols = codecNames(config.Codecs)
+		dialer = &withCodecs
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, url, config.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to dial %s: %w (status %d)", url, err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("failed to dial %s: %w", url, err)
+	}
+
+	return newConn(logger, conn, config.Config), nil
+}
+
+// codecNames returns the subprotocol names of the given codecs
+func codecNames(codecs []message.Codec) []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package websocket
+
+import (
+	"net/http"
+
+	ws "github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pavliha/aircast-sdk/pkg/message"
+)
+
+// HandlerConfig holds the settings used by the API to accept connections
+type HandlerConfig struct {
+	Config
+
+	// CheckOrigin validates the request origin. When nil, cross-origin
+	// requests are rejected.
+	CheckOrigin func(r *http.Request) bool
+
+	// Codecs are the subprotocols accepted from clients, in order of
+	// preference. JSON is used when the client offers none of them.
+	Codecs []message.Codec
+}
+
+// ConnectFunc is called for every accepted connection. It runs on the
+// request goroutine and may block for the lifetime of the connection.
+type ConnectFunc func(conn *Conn, r *http.Request)
+
+// handler upgrades HTTP requests to WebSocket connections
+type handler struct {
+	logger    *log.Entry
+	config    HandlerConfig
+	upgrader  ws.Upgrader
+	onConnect ConnectFunc
+}
+
+// NewHandler creates an http.Handler that upgrades requests and passes the
+// resulting connections to onConnect
+func NewHandler(logger *log.Entry, config HandlerConfig, onConnect ConnectFunc) http.Handler {
+	return &handler{
+		logger: logger.WithField("component", "websocket_handler"),
+		config: config,
+		upgrader: ws.Upgrader{
+			CheckOrigin:  config.CheckOrigin,
+			Subprotocols: codecNames(config.Codecs),
+		},
+		onConnect: onConnect,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		h.logger.WithError(err).Debug("Failed to upgrade connection")
+		return
+	}
+
+	h.onConnect(newConn(h.logger, conn, h.config.Config), r)
+}